@@ -0,0 +1,26 @@
+module github.com/sbinet/go-root2pb
+
+go 1.22.0
+
+require (
+	go-hep.org/x/hep v0.36.0
+	google.golang.org/protobuf v1.36.6
+)
+
+require (
+	github.com/go-mmap/mmap v0.7.0 // indirect
+	github.com/gonuts/binary v0.2.0 // indirect
+	github.com/hashicorp/go-uuid v1.0.3 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
+	github.com/pierrec/xxHash v0.1.5 // indirect
+	github.com/ulikunitz/xz v0.5.12 // indirect
+	golang.org/x/exp v0.0.0-20241009180824-f66d83c29e7c // indirect
+	golang.org/x/mod v0.21.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
+	golang.org/x/text v0.19.0 // indirect
+	golang.org/x/tools v0.26.0 // indirect
+	gonum.org/v1/gonum v0.15.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -3,15 +3,15 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
-	"text/template"
 
-	// import so root2pb-cnv is up to date...
-	_ "github.com/sbinet/go-root2pb/pbutils"
+	"github.com/sbinet/go-root2pb/root2pb"
+	"github.com/sbinet/go-root2pb/rootutils"
+	"github.com/sbinet/go-root2pb/templates"
 )
 
 var fname = flag.String("f", "", "path to input ROOT file")
@@ -21,98 +21,18 @@ var brsel = flag.String("sel", "", "comma-separated list of glob-patterns to sel
 var pb_pkg_name = flag.String("pkg", "event", "name of the protobuf package to be generated")
 var pb_msg_name = flag.String("msg", "Event", "name of the top-level message encoding the TTree")
 var do_gen = flag.String("gen", "", "generate the pb file(s) from the .proto one for each of output languages (go,py,cpp,java)")
-var do_cnv = flag.Bool("cnv", false, "convert the ROOT TTree's content into a binary pbuf file using the generated .pb.go package")
+var do_cnv = flag.Bool("cnv", false, "convert the ROOT TTree's content into a binary pbuf file")
+var do_gencnv = flag.Bool("gencnv", false, "with -cnv, generate and run a standalone Go converter using the generated .pb.go package (needs a Go toolchain)")
+var on_unsupported = flag.String("on-unsupported", "error", "what to do with branches whose type cannot be mapped to protobuf (error|skip|bytes)")
+var typemap = flag.String("typemap", "", "path to a JSON file extending or overriding the mapping of ROOT types to protobuf types")
+var lockname = flag.String("lock", "", "path to the field-number lock file keeping field numbers stable across regenerations (default: the .proto file with a .lock extension)")
+var check_compat = flag.Bool("check-compat", false, "check the new .proto file is compatible with the previous one (descr.pbuf in the output directory) and fail if it is not")
+var syntax = flag.String("syntax", "proto2", "syntax of the generated .proto file (proto2|proto3)")
+var tmpldir = flag.String("templates", "", "directory of templates (proto2.proto.tmpl, proto3.proto.tmpl, options.proto.tmpl, cnv.go.tmpl) overriding the embedded ones")
+var proto_templ = flag.String("proto-template", "", "path to a template file of the .proto file, executed against the schema (see the README)")
 var verbose = flag.Bool("v", false, "verbose")
 
-var rt2pb_typemap = map[string]string{
-	"Char_t":   "bytes",
-	"Bool_t":   "bool",
-	"UInt_t":   "uint32",
-	"Int_t":    "int32",
-	"Int32_t":  "int32",
-	"Long_t":   "int64",
-	"Long64_t": "int64",
-	"Float_t":  "float",
-	"Double_t": "double",
-
-	"std::string": "string",
-	"string":      "string",
-
-	"unsigned short": "uint32",
-	"unsigned int":   "uint32",
-	"unsigned long":  "uint64",
-
-	"short": "int32",
-	"int":   "int32",
-	"long":  "int64",
-}
-
-func get_pb_type(typename string) (pb_type string, isrepeated bool) {
-	v, ok := rt2pb_typemap[typename]
-	if ok {
-		return v, false
-	}
-	v = typename
-	if strings.HasPrefix(v, "vector<") {
-		v = strings.TrimSpace(v[len("vector<") : len(v)-1])
-		isrepeated = true
-	}
-	if strings.HasPrefix(v, "std::vector<") {
-		v = strings.TrimSpace(v[len("std::vector<") : len(v)-1])
-		isrepeated = true
-	}
-	if isrepeated {
-		v, _ = get_pb_type(v)
-	}
-	return v, isrepeated
-}
-
-type pb_package struct {
-	Package string
-	Message string
-	Fields  []pb_field
-}
-
-// pb_field encodes the informations about a tree's branch or leaf.
-type pb_field struct {
-	Name     string
-	Type     string
-	Id       int
-	Branch   string
-	repeated bool
-	//tag     string
-}
-
-func (f pb_field) Attr() string {
-	attrs := []string{fmt.Sprintf(`(root_branch) = %q`, f.Branch)}
-	if f.repeated && f.Type != "string" {
-		attrs = append(attrs, "packed=true")
-	}
-	if len(attrs) > 0 {
-		return fmt.Sprintf(" [%s]", strings.Join(attrs, ","))
-	}
-	return ""
-}
-
-func (f pb_field) Modifier() string {
-	if f.repeated {
-		return "repeated"
-	}
-	//return "required"
-	return "optional"
-}
-
-var gen_id = make(chan int, 1)
-
 func main() {
-	go func() {
-		cnt := 1
-		for {
-			gen_id <- cnt
-			cnt += 1
-		}
-	}()
-
 	flag.Parse()
 
 	if *fname == "" || *tname == "" {
@@ -131,8 +51,6 @@ func main() {
 	fmt.Printf(":: tree:        [%s]\n", *tname)
 	fmt.Printf(":: selection:   [%s]\n", *brsel)
 
-	dname := path.Join(outdir, "descr.pbuf")
-
 	abspath, err := filepath.Abs(*oname)
 	if err != nil {
 		fmt.Printf("**error** could not compute absolute path: %v\n", err)
@@ -140,6 +58,7 @@ func main() {
 	}
 	*oname = abspath
 	outdir = path.Dir(*oname)
+	dname := path.Join(outdir, "descr.pbuf")
 
 	if !path_exists(outdir) {
 		err := os.Mkdir(outdir, os.ModeDir|os.ModePerm)
@@ -149,72 +68,116 @@ func main() {
 		}
 	}
 
-	pb_fields := inspect_root_file(*fname, *tname)
+	var tmpls fs.FS = templates.FS
+	if *tmpldir != "" {
+		if !path_exists(*tmpldir) {
+			fmt.Printf("**error** no such templates directory [%s]\n", *tmpldir)
+			os.Exit(1)
+		}
+		tmpls = templates.Dir(*tmpldir)
+	}
 
-	fmt.Printf(":: generating .proto file...\n")
-	t := template.New("Protobuf package template")
-	t, err = t.Parse(pb_pkg_templ)
+	var tmap root2pb.TypeMap
+	if *typemap != "" {
+		tmap, err = root2pb.LoadTypeMap(*typemap)
+		if err != nil {
+			fmt.Printf("**error** %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *lockname == "" {
+		*lockname = strings.TrimSuffix(*oname, ".proto") + ".lock"
+	}
+	lock, err := root2pb.LoadFieldLock(*lockname)
 	if err != nil {
 		fmt.Printf("**error** %v\n", err)
 		os.Exit(1)
 	}
 
-	pb_file, err := os.Create(*oname)
+	tree, err := rootutils.Open(*fname, *tname)
 	if err != nil {
 		fmt.Printf("**error** %v\n", err)
 		os.Exit(1)
 	}
+	schema, err := root2pb.InferSchema(tree, root2pb.Options{
+		Package:       *pb_pkg_name,
+		Message:       *pb_msg_name,
+		Selection:     *brsel,
+		OnUnsupported: *on_unsupported,
+		Syntax:        *syntax,
+		TypeMap:       tmap,
+		Lock:          lock,
+	})
+	tree.Close()
+	if err != nil {
+		fmt.Printf("**error** %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("   #-fields: %v\n", len(schema.Fields))
+	if *verbose {
+		for i, f := range schema.Fields {
+			fmt.Printf(" [%d] -> [%v] (type:%v)\n", i, f.Branch, f.Type)
+		}
+	}
+	if len(schema.Skipped) > 0 {
+		fmt.Printf("   #-skipped: %v (unsupported types)\n", len(schema.Skipped))
+		for _, br := range schema.Skipped {
+			fmt.Printf("   - [%v] (type:%v)\n", br.Name, br.TypeName)
+		}
+	}
 
-	pb_pkg := pb_package{
-		Package: *pb_pkg_name,
-		Message: *pb_msg_name,
-		Fields:  pb_fields,
+	if *check_compat && path_exists(dname) {
+		fmt.Printf(":: checking compatibility with [%s]...\n", dname)
+		fdset, err := root2pb.LoadDescriptorSet(dname)
+		if err != nil {
+			fmt.Printf("**error** %v\n", err)
+			os.Exit(1)
+		}
+		errs := root2pb.CheckCompat(fdset, schema)
+		if len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Printf("**error** %v\n", err)
+			}
+			os.Exit(1)
+		}
+		fmt.Printf(":: checking compatibility with [%s]... [ok]\n", dname)
 	}
 
-	err = t.Execute(pb_file, pb_pkg)
+	fmt.Printf(":: generating .proto file...\n")
+	onames, err := write_proto(*oname, schema, tmpls, *proto_templ)
 	if err != nil {
 		fmt.Printf("**error** %v\n", err)
 		os.Exit(1)
 	}
+	err = lock.Save(*lockname)
+	if err != nil {
+		fmt.Printf("**error** could not write lock file: %v\n", err)
+		os.Exit(1)
+	}
 	fmt.Printf(":: generating .proto file...[done]\n")
 
 	if *do_gen != "" || *do_cnv {
-		args := []string{}
-		if strings.Contains(*do_gen, "go") || *do_cnv {
-			args = append(args, "--go_out=.")
-		}
-		if strings.Contains(*do_gen, "py") {
-			args = append(args, "--python_out=.")
-		}
-		if strings.Contains(*do_gen, "java") {
-			args = append(args, "--java_out=.")
-		}
-		if strings.Contains(*do_gen, "cpp") ||
-			strings.Contains(*do_gen, "cxx") {
-			args = append(args, "--cpp_out=.")
+		langs := *do_gen
+		if *do_cnv && *do_gencnv {
+			langs += ",go"
 		}
-
-		args = append(args,
-			fmt.Sprintf("--descriptor_set_out=%s", dname),
-			"-I", outdir,
-			"-I", "/usr/include",
-			*oname)
-		cmd := exec.Command("protoc", args...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Dir = outdir
-		err = cmd.Run()
+		err = run_protoc(langs, dname, go_import_path(schema.Package), onames...)
 		if err != nil {
 			fmt.Printf("**error** running protoc: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Printf(":: pb file(s) generated.\n")
-
 	}
 
 	if *do_cnv {
 		fmt.Printf(":: converting ROOT Tree's content into a pbuf...\n")
-		err = convert_tree(*fname, *tname, dname)
+		pbuf := get_pbuf_name(*fname, dname)
+		if *do_gencnv {
+			err = gen_convert_tree(*fname, *tname, dname, pbuf, tmpls)
+		} else {
+			err = root2pb.ConvertFile(*fname, *tname, dname, pbuf)
+		}
 		if err != nil {
 			fmt.Printf("**error** converting ROOT Tree: %v\n", err)
 			os.Exit(1)
@@ -0,0 +1,117 @@
+// Package "pbio" provides tools to read and write the .pbuf files produced
+// by go-root2pb.
+//
+// A .pbuf file is a sequence of records, each record being a serialized
+// protobuf message prefixed with its length, encoded as a varint.
+// The first record is a DataHeader message, followed by one record per
+// event.
+package pbio
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// DataHeader is the header record of a .pbuf file.
+// It is wire-compatible with the DataHeader message of the generated .proto
+// file, so a .pbuf file can be read without the generated Go package.
+type DataHeader struct {
+	// Set of .proto files which define the type.
+	ProtoFiles *descriptorpb.FileDescriptorSet
+	// number of entries in the payload message
+	Nevts *uint64
+}
+
+func (m *DataHeader) GetProtoFiles() *descriptorpb.FileDescriptorSet {
+	if m != nil {
+		return m.ProtoFiles
+	}
+	return nil
+}
+
+func (m *DataHeader) GetNevts() uint64 {
+	if m != nil && m.Nevts != nil {
+		return *m.Nevts
+	}
+	return 0
+}
+
+// nevts_width is the number of bytes used to encode the DataHeader's nevts.
+// nevts is encoded as a zero-padded varint of fixed width, so it can be
+// overwritten in place once the number of events is known.
+const nevts_width = 10
+
+// encode_header encodes hdr, with its nevts field encoded last and with a
+// fixed width.
+// It returns the encoded header and the offset of the nevts value.
+func encode_header(hdr *DataHeader) ([]byte, int64, error) {
+	var data []byte
+	if hdr.ProtoFiles != nil {
+		fdset, err := proto.Marshal(hdr.ProtoFiles)
+		if err != nil {
+			return nil, 0, err
+		}
+		data = protowire.AppendTag(data, 1, protowire.BytesType)
+		data = protowire.AppendBytes(data, fdset)
+	}
+	data = protowire.AppendTag(data, 2, protowire.VarintType)
+	ipos := int64(len(data))
+	data = append(data, encode_nevts(hdr.GetNevts())...)
+	return data, ipos, nil
+}
+
+// decode_header decodes a DataHeader from data.
+// Unknown fields are ignored.
+func decode_header(data []byte, hdr *DataHeader) error {
+	*hdr = DataHeader{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("pbio: invalid header: %v", protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return fmt.Errorf("pbio: invalid header: %v", protowire.ParseError(n))
+			}
+			hdr.ProtoFiles = &descriptorpb.FileDescriptorSet{}
+			err := proto.Unmarshal(v, hdr.ProtoFiles)
+			if err != nil {
+				return err
+			}
+			data = data[n:]
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return fmt.Errorf("pbio: invalid header: %v", protowire.ParseError(n))
+			}
+			hdr.Nevts = proto.Uint64(v)
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return fmt.Errorf("pbio: invalid header: %v", protowire.ParseError(n))
+			}
+			data = data[n:]
+		}
+	}
+	return nil
+}
+
+// encode_nevts encodes n as a varint of nevts_width bytes.
+func encode_nevts(n uint64) []byte {
+	data := make([]byte, nevts_width)
+	for i := range data {
+		data[i] = byte(n&0x7f) | 0x80
+		n >>= 7
+	}
+	data[nevts_width-1] &= 0x7f
+	return data
+}
+
+// EOF
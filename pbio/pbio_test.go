@@ -0,0 +1,307 @@
+package pbio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// stream hides the Seek method of the underlying reader or writer.
+type stream struct {
+	io.ReadWriter
+}
+
+func new_fdset() *descriptorpb.FileDescriptorSet {
+	return &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(wrapperspb.File_google_protobuf_wrappers_proto),
+		},
+	}
+}
+
+// write_file writes a .pbuf file with nevts events (0, 10, 20, ...)
+func write_file(t *testing.T, nevts int) string {
+	fname := filepath.Join(t.TempDir(), "data.pbuf")
+	w, err := Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.WriteHeader(&DataHeader{ProtoFiles: new_fdset()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < nevts; i++ {
+		err = w.WriteEvent(wrapperspb.Int64(int64(10 * i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.Nevts() != int64(nevts) {
+		t.Fatalf("writer nevts: got %d, want %d", w.Nevts(), nevts)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func TestRoundTrip(t *testing.T) {
+	const nevts = 5
+	fname := write_file(t, nevts)
+
+	r, err := Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// nevts is patched in place, as no Nevts was declared.
+	if r.Nevts() != nevts {
+		t.Fatalf("nevts: got %d, want %d", r.Nevts(), nevts)
+	}
+	if !proto.Equal(r.Header().GetProtoFiles(), new_fdset()) {
+		t.Fatalf("header descriptors differ")
+	}
+
+	evt := &wrapperspb.Int64Value{}
+	n := 0
+	for r.Next(evt) {
+		if want := int64(10 * n); evt.GetValue() != want {
+			t.Fatalf("event %d: got %d, want %d", n, evt.GetValue(), want)
+		}
+		n++
+		if r.Entry() != int64(n) {
+			t.Fatalf("entry: got %d, want %d", r.Entry(), n)
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	if n != nevts {
+		t.Fatalf("events read: got %d, want %d", n, nevts)
+	}
+}
+
+func TestNevtsPatched(t *testing.T) {
+	// a wrong declared number of events is fixed on a seekable stream.
+	fname := filepath.Join(t.TempDir(), "data.pbuf")
+	w, err := Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.WriteHeader(&DataHeader{Nevts: proto.Uint64(1000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		err = w.WriteEvent(wrapperspb.Int64(int64(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Nevts() != 3 {
+		t.Fatalf("nevts: got %d, want 3", r.Nevts())
+	}
+}
+
+func TestNevtsMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		declared uint64
+		written  int
+		ok       bool
+	}{
+		{declared: 3, written: 3, ok: true},
+		{declared: 3, written: 2, ok: false},
+		{declared: 1, written: 2, ok: false},
+	} {
+		buf := new(bytes.Buffer)
+		w := NewWriter(stream{buf})
+		err := w.WriteHeader(&DataHeader{Nevts: proto.Uint64(tc.declared)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < tc.written; i++ {
+			err = w.WriteEvent(wrapperspb.Int64(int64(i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = w.Close()
+		if tc.ok != (err == nil) {
+			t.Fatalf("declared=%d written=%d: unexpected error status: %v",
+				tc.declared, tc.written, err)
+		}
+		if !tc.ok {
+			continue
+		}
+
+		r, err := NewReader(stream{buf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Nevts() != int64(tc.declared) {
+			t.Fatalf("nevts: got %d, want %d", r.Nevts(), tc.declared)
+		}
+		evt := &wrapperspb.Int64Value{}
+		err = r.ReadEntry(0, evt)
+		if !errors.Is(err, ErrNoIndex) {
+			t.Fatalf("ReadEntry on a stream: got %v, want ErrNoIndex", err)
+		}
+		n := 0
+		for r.Next(evt) {
+			n++
+		}
+		if r.Err() != nil || n != tc.written {
+			t.Fatalf("read %d events (err=%v), want %d", n, r.Err(), tc.written)
+		}
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	w := NewWriter(new(bytes.Buffer))
+	err := w.WriteEvent(wrapperspb.Int64(1))
+	if err == nil {
+		t.Fatalf("expected an error writing an event before the header")
+	}
+	// nevts can not be patched on a non-seekable stream.
+	err = w.WriteHeader(&DataHeader{})
+	if err == nil {
+		t.Fatalf("expected an error writing a header without nevts to a stream")
+	}
+	err = w.WriteHeader(&DataHeader{Nevts: proto.Uint64(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.WriteHeader(&DataHeader{Nevts: proto.Uint64(0)})
+	if err == nil {
+		t.Fatalf("expected an error writing the header twice")
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.WriteEvent(wrapperspb.Int64(1))
+	if err == nil {
+		t.Fatalf("expected an error writing to a closed writer")
+	}
+}
+
+func TestReadEntry(t *testing.T) {
+	const nevts = 5
+	r, err := Open(write_file(t, nevts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	evt := &wrapperspb.Int64Value{}
+	// forward, then backwards.
+	for _, i := range []int64{3, 1, 4, 0, 2} {
+		err = r.ReadEntry(i, evt)
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		if evt.GetValue() != 10*i {
+			t.Fatalf("entry %d: got %d, want %d", i, evt.GetValue(), 10*i)
+		}
+	}
+
+	// Next continues after the last entry read.
+	if !r.Next(evt) || evt.GetValue() != 30 {
+		t.Fatalf("next after entry 2: got %d (err=%v), want 30", evt.GetValue(), r.Err())
+	}
+
+	for _, i := range []int64{nevts, nevts + 10} {
+		err = r.ReadEntry(i, evt)
+		if err == nil {
+			t.Fatalf("entry %d: expected an out of range error", i)
+		}
+		if !strings.Contains(err.Error(), "[0, 5)") {
+			t.Fatalf("entry %d: unexpected error: %v", i, err)
+		}
+	}
+	err = r.ReadEntry(-1, evt)
+	if err == nil {
+		t.Fatalf("expected an error for a negative entry")
+	}
+
+	// failed calls leave the position unchanged.
+	if !r.Next(evt) || evt.GetValue() != 40 {
+		t.Fatalf("next after failed reads: got %d (err=%v), want 40", evt.GetValue(), r.Err())
+	}
+	if r.Next(evt) || r.Err() != nil {
+		t.Fatalf("expected a clean end of file (err=%v)", r.Err())
+	}
+}
+
+func TestCorrupted(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	err := w.WriteHeader(&DataHeader{Nevts: proto.Uint64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.WriteEvent(wrapperspb.Int64(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// huge length prefix for the first event.
+	bad := append([]byte{}, data[:len(data)-3]...)
+	bad = append(bad, 0xff, 0xff, 0xff, 0xff, 0x0f)
+	for _, rr := range []io.Reader{bytes.NewReader(bad), stream{bytes.NewBuffer(bad)}} {
+		r, err := NewReader(rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Next(&wrapperspb.Int64Value{}) || r.Err() == nil {
+			t.Fatalf("expected an error reading a corrupted record")
+		}
+	}
+
+	// truncated event.
+	for _, rr := range []io.Reader{
+		bytes.NewReader(data[:len(data)-1]),
+		stream{bytes.NewBuffer(data[:len(data)-1])},
+	} {
+		r, err := NewReader(rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Next(&wrapperspb.Int64Value{}) || r.Err() == nil {
+			t.Fatalf("expected an error reading a truncated record")
+		}
+	}
+
+	// truncated header.
+	_, err = NewReader(bytes.NewReader(data[:3]))
+	if err == nil {
+		t.Fatalf("expected an error reading a truncated header")
+	}
+}
+
+// EOF
@@ -0,0 +1,291 @@
+package pbio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrNoIndex is returned by Reader.ReadEntry when the underlying stream
+// does not support random access.
+var ErrNoIndex = errors.New("pbio: no index available (stream is not seekable)")
+
+// Reader reads events from a .pbuf file.
+type Reader struct {
+	r   *bufio.Reader
+	rs  io.ReadSeeker // underlying stream, if it supports random access
+	c   io.Closer     // underlying file, if owned by the Reader
+	hdr DataHeader
+	buf []byte
+	err error
+
+	pos  int64 // current offset in the underlying stream
+	size int64 // size of the underlying stream, if seekable (-1 otherwise)
+	ievt int64 // index of the next event to be read
+
+	// offsets of the events located so far.
+	// events up to len(index) can be accessed randomly.
+	index []int64
+}
+
+// Open opens the named .pbuf file and decodes its DataHeader.
+func Open(name string) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.c = f
+	return r, nil
+}
+
+// NewReader returns a Reader reading from r, after having decoded the
+// DataHeader record.
+// Random access via ReadEntry is available if r is an io.ReadSeeker.
+func NewReader(r io.Reader) (*Reader, error) {
+	rr := &Reader{
+		r:    bufio.NewReader(r),
+		size: -1,
+	}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		pos, err := rs.Seek(0, io.SeekCurrent)
+		if err == nil {
+			size, err := rs.Seek(0, io.SeekEnd)
+			if err == nil {
+				_, err = rs.Seek(pos, io.SeekStart)
+			}
+			if err != nil {
+				return nil, err
+			}
+			rr.rs = rs
+			rr.pos = pos
+			rr.size = size
+		}
+	}
+
+	data, err := rr.read_record()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	err = decode_header(data, &rr.hdr)
+	if err != nil {
+		return nil, err
+	}
+	rr.index = append(rr.index, rr.pos)
+	return rr, nil
+}
+
+// Header returns the DataHeader of the file.
+func (r *Reader) Header() *DataHeader {
+	return &r.hdr
+}
+
+// Nevts returns the number of events declared in the DataHeader.
+func (r *Reader) Nevts() int64 {
+	return int64(r.hdr.GetNevts())
+}
+
+// Entry returns the index of the next event Next will decode.
+func (r *Reader) Entry() int64 {
+	return r.ievt
+}
+
+// Next decodes the next event into evt.
+// It returns false when there are no more events or an error occured.
+// Err should be called to distinguish between the two cases.
+func (r *Reader) Next(evt proto.Message) bool {
+	if r.err != nil {
+		return false
+	}
+	data, err := r.read_record()
+	if err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+		return false
+	}
+	err = proto.Unmarshal(data, evt)
+	if err != nil {
+		r.err = err
+		return false
+	}
+	r.ievt++
+	if r.ievt == int64(len(r.index)) {
+		r.index = append(r.index, r.pos)
+	}
+	return true
+}
+
+// Err returns the first non-EOF error encountered by Next.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// ReadEntry decodes the i-th event into evt.
+// Subsequent calls to Next will decode the events following the i-th one.
+// ReadEntry returns ErrNoIndex if the underlying stream is not seekable.
+func (r *Reader) ReadEntry(i int64, evt proto.Message) error {
+	if r.rs == nil {
+		return ErrNoIndex
+	}
+	if i < 0 {
+		return errors.New("pbio: negative entry index")
+	}
+	pos, ievt := r.pos, r.ievt
+	err := r.read_entry(i, evt)
+	if err != nil {
+		r.seek(pos)
+		r.ievt = ievt
+		return err
+	}
+	return nil
+}
+
+func (r *Reader) read_entry(i int64, evt proto.Message) error {
+	err := r.build_index(i)
+	if err != nil {
+		return err
+	}
+	err = r.seek(r.index[i])
+	if err != nil {
+		return err
+	}
+	data, err := r.read_record()
+	if err != nil {
+		if err == io.EOF {
+			// the i-th event would start at the end of the stream.
+			err = fmt.Errorf("pbio: entry %d out of range [0, %d)", i, len(r.index)-1)
+		}
+		return err
+	}
+	err = proto.Unmarshal(data, evt)
+	if err != nil {
+		return err
+	}
+	r.ievt = i + 1
+	r.err = nil
+	return nil
+}
+
+// Close closes the underlying file if the Reader was created with Open.
+func (r *Reader) Close() error {
+	if r.c == nil {
+		return nil
+	}
+	return r.c.Close()
+}
+
+// build_index locates the offsets of the events up to the i-th one,
+// skipping over their payload.
+func (r *Reader) build_index(i int64) error {
+	if i < int64(len(r.index)) {
+		return nil
+	}
+	err := r.seek(r.index[len(r.index)-1])
+	if err != nil {
+		return err
+	}
+	for int64(len(r.index)) <= i {
+		n, err := r.read_uvarint()
+		if err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("pbio: entry %d out of range [0, %d)", i, len(r.index)-1)
+			}
+			return err
+		}
+		err = r.check_size(n)
+		if err != nil {
+			return err
+		}
+		err = r.seek(r.pos + int64(n))
+		if err != nil {
+			return err
+		}
+		r.index = append(r.index, r.pos)
+	}
+	return nil
+}
+
+func (r *Reader) seek(pos int64) error {
+	_, err := r.rs.Seek(pos, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r.r.Reset(r.rs)
+	r.pos = pos
+	return nil
+}
+
+// read_record reads a length-prefixed record.
+// It returns io.EOF if the stream ended cleanly before the record.
+func (r *Reader) read_record() ([]byte, error) {
+	n, err := r.read_uvarint()
+	if err != nil {
+		return nil, err
+	}
+	err = r.check_size(n)
+	if err != nil {
+		return nil, err
+	}
+	// the buffer grows as the data is read, so a corrupted length prefix
+	// cannot trigger an allocation larger than the stream.
+	buf := bytes.NewBuffer(r.buf[:0])
+	nn, err := io.CopyN(buf, r.r, int64(n))
+	r.pos += nn
+	r.buf = buf.Bytes()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return r.buf, nil
+}
+
+// max_record_size is the maximum size of a record: protobuf messages are
+// limited to 2GB, and a corrupted length prefix must not trigger a huge
+// allocation.
+const max_record_size = 1 << 30
+
+// check_size checks the size n of the record starting at the current
+// offset fits in the stream.
+func (r *Reader) check_size(n uint64) error {
+	if n > max_record_size || (r.size >= 0 && r.pos+int64(n) > r.size) {
+		return fmt.Errorf("pbio: invalid record size %d at offset %d (corrupted or truncated file)",
+			n, r.pos)
+	}
+	return nil
+}
+
+func (r *Reader) read_uvarint() (uint64, error) {
+	var x uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && shift > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		r.pos++
+		x |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return x, nil
+		}
+	}
+	return 0, errors.New("pbio: varint overflow")
+}
+
+// EOF
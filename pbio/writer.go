@@ -0,0 +1,182 @@
+package pbio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Writer writes events to a .pbuf file.
+//
+// When the underlying stream is seekable, the DataHeader's nevts is patched
+// with the number of events actually written when the Writer is closed.
+type Writer struct {
+	w   *bufio.Writer
+	ws  io.WriteSeeker // underlying stream, if it supports random access
+	c   io.Closer      // underlying file, if owned by the Writer
+	hdr *DataHeader
+	err error
+
+	pos   int64 // current offset in the underlying stream
+	ipos  int64 // offset of the DataHeader's nevts value
+	nevts int64 // number of events written so far
+}
+
+// Create creates the named .pbuf file and returns a Writer writing to it.
+func Create(name string) (*Writer, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	w := NewWriter(f)
+	w.c = f
+	return w, nil
+}
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	ww := &Writer{
+		w: bufio.NewWriter(w),
+	}
+	if ws, ok := w.(io.WriteSeeker); ok {
+		pos, err := ws.Seek(0, io.SeekCurrent)
+		if err == nil {
+			ww.ws = ws
+			ww.pos = pos
+		}
+	}
+	return ww
+}
+
+// WriteHeader writes the DataHeader record.
+// It must be called once, before any call to WriteEvent.
+//
+// If the underlying stream is seekable, hdr.Nevts need not be set: it is
+// filled with the number of events written when the Writer is closed.
+// Otherwise hdr.Nevts must declare the number of events to be written.
+func (w *Writer) WriteHeader(hdr *DataHeader) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.hdr != nil {
+		return errors.New("pbio: header already written")
+	}
+	if w.ws == nil && hdr.Nevts == nil {
+		return errors.New("pbio: nevts must be declared on a non-seekable stream")
+	}
+	data, ipos, err := encode_header(hdr)
+	if err != nil {
+		return err
+	}
+	w.hdr = hdr
+	w.ipos = w.pos + int64(protowire.SizeVarint(uint64(len(data)))) + ipos
+	return w.write_record(data)
+}
+
+// WriteEvent writes evt as the next event record.
+func (w *Writer) WriteEvent(evt proto.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.hdr == nil {
+		return errors.New("pbio: header not written")
+	}
+	err := w.write_msg(evt)
+	if err != nil {
+		return err
+	}
+	w.nevts++
+	return nil
+}
+
+// Nevts returns the number of events written so far.
+func (w *Writer) Nevts() int64 {
+	return w.nevts
+}
+
+// Close flushes the buffered records to the underlying stream, records
+// the number of events written into the DataHeader and closes the
+// underlying file if the Writer was created with Create.
+// If the underlying stream is not seekable, Close returns an error when the
+// number of events written differs from the one declared in the DataHeader.
+func (w *Writer) Close() error {
+	err := w.err
+	if err == nil {
+		err = w.w.Flush()
+	}
+	if err == nil && w.hdr != nil {
+		err = w.finalize()
+	}
+	if w.c != nil {
+		if f, ok := w.c.(*os.File); ok && err == nil {
+			err = f.Sync()
+		}
+		errc := w.c.Close()
+		if err == nil {
+			err = errc
+		}
+		w.c = nil
+	}
+	if w.err == nil {
+		w.err = errors.New("pbio: writer closed")
+	}
+	return err
+}
+
+// finalize records the number of events written into the DataHeader,
+// overwriting the nevts value in place if the stream is seekable.
+func (w *Writer) finalize() error {
+	nevts := uint64(w.nevts)
+	if w.ws == nil {
+		if w.hdr.Nevts != nil && w.hdr.GetNevts() != nevts {
+			return fmt.Errorf("pbio: header declares %d events but %d were written",
+				w.hdr.GetNevts(), nevts)
+		}
+		return nil
+	}
+	_, err := w.ws.Seek(w.ipos, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = w.ws.Write(encode_nevts(nevts))
+	if err != nil {
+		return err
+	}
+	_, err = w.ws.Seek(w.pos, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	w.hdr.Nevts = proto.Uint64(nevts)
+	return nil
+}
+
+func (w *Writer) write_msg(msg proto.Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return w.write_record(data)
+}
+
+func (w *Writer) write_record(data []byte) error {
+	n := protowire.AppendVarint(nil, uint64(len(data)))
+	_, err := w.w.Write(n)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	_, err = w.w.Write(data)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.pos += int64(len(n) + len(data))
+	return nil
+}
+
+// EOF
@@ -0,0 +1,343 @@
+package pbutils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Message holds the Go values of the fields of a protobuf message, to be
+// set on a generated or dynamic protobuf message.
+//
+// Values holds one Go value per field of the descriptor (in the order of
+// Descr.Fields()); repeated fields take slices or arrays.
+// Invalid (zero) reflect.Values denote unset fields.
+//
+// Fields of message type take a *Message or, if the message type has a
+// single field (e.g. the wrapper of a nested vector), the value of that
+// field.
+type Message struct {
+	Descr  protoreflect.MessageDescriptor
+	Values []reflect.Value
+}
+
+// NewMessage returns a new Message, with all its fields unset.
+func NewMessage(descr protoreflect.MessageDescriptor) *Message {
+	return &Message{
+		Descr:  descr,
+		Values: make([]reflect.Value, descr.Fields().Len()),
+	}
+}
+
+func (m *Message) Reset() {
+	for i := range m.Values {
+		m.Values[i] = reflect.Value{}
+	}
+}
+
+func (m *Message) String() string {
+	str := string(m.Descr.Name()) + "{"
+	fields := m.Descr.Fields()
+	for i, v := range m.Values {
+		if !v.IsValid() {
+			continue
+		}
+		str += fmt.Sprintf("%s:%v ", fields.Get(i).Name(), reflect.Indirect(v).Interface())
+	}
+	return str + "}"
+}
+
+// New returns a new dynamic message holding the values of m.
+func (m *Message) New() (*dynamicpb.Message, error) {
+	msg := dynamicpb.NewMessage(m.Descr)
+	err := m.Fill(msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Fill sets the fields of dst with the values of m.
+// The fields of dst are matched with the ones of m by number, so dst may be
+// a generated message of the same type as m.
+func (m *Message) Fill(dst protoreflect.Message) error {
+	fields := m.Descr.Fields()
+	dfields := dst.Descriptor().Fields()
+	for i, v := range m.Values {
+		fd := dfields.ByNumber(fields.Get(i).Number())
+		if fd == nil {
+			return fmt.Errorf("pbutils: no field [%s] in message [%s]",
+				fields.Get(i).Name(), dst.Descriptor().FullName())
+		}
+		err := Set(dst, fd, v)
+		if err != nil {
+			return fmt.Errorf("pbutils: field [%s.%s]: %v",
+				m.Descr.Name(), fd.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Set sets the field fd of the message dst with the Go value v, converting
+// it to the type of the field.
+// The field is cleared if v is invalid.
+func Set(dst protoreflect.Message, fd protoreflect.FieldDescriptor, v reflect.Value) error {
+	dst.Clear(fd)
+	if !v.IsValid() {
+		return nil
+	}
+	v = reflect.Indirect(v)
+	if !v.IsValid() {
+		return nil
+	}
+
+	switch {
+	case fd.IsList():
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array:
+		default:
+			return fmt.Errorf("repeated field needs a slice or an array (got %v)", v.Type())
+		}
+		if v.Len() == 0 {
+			return nil
+		}
+		list := dst.Mutable(fd).List()
+		for i := 0; i < v.Len(); i++ {
+			if fd.Message() != nil {
+				elem := list.NewElement()
+				err := set_message(elem.Message(), v.Index(i))
+				if err != nil {
+					return err
+				}
+				list.Append(elem)
+				continue
+			}
+			elem, err := value(fd, v.Index(i))
+			if err != nil {
+				return err
+			}
+			list.Append(elem)
+		}
+		return nil
+
+	case fd.Message() != nil:
+		return set_message(dst.Mutable(fd).Message(), v)
+	}
+
+	pv, err := value(fd, v)
+	if err != nil {
+		return err
+	}
+	dst.Set(fd, pv)
+	return nil
+}
+
+// set_message sets the fields of the message dst with v, a Message or the
+// value of the single field of dst.
+func set_message(dst protoreflect.Message, v reflect.Value) error {
+	v = reflect.Indirect(v)
+	if v.Type() == reflect.TypeOf(Message{}) {
+		msg := v.Interface().(Message)
+		return msg.Fill(dst)
+	}
+	fields := dst.Descriptor().Fields()
+	if fields.Len() != 1 {
+		return fmt.Errorf("cannot set %v as a [%s] message", v.Type(), dst.Descriptor().FullName())
+	}
+	return Set(dst, fields.Get(0), v)
+}
+
+// value converts the Go value v into a value of the (scalar) type of the
+// field fd.
+func value(fd protoreflect.FieldDescriptor, v reflect.Value) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		x, err := as_uint(v)
+		return protoreflect.ValueOfBool(x != 0), err
+	case protoreflect.EnumKind:
+		x, err := as_int(v)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(x)), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		x, err := as_int(v)
+		return protoreflect.ValueOfInt32(int32(x)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		x, err := as_int(v)
+		return protoreflect.ValueOfInt64(x), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		x, err := as_uint(v)
+		return protoreflect.ValueOfUint32(uint32(x)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		x, err := as_uint(v)
+		return protoreflect.ValueOfUint64(x), err
+	case protoreflect.FloatKind:
+		x, err := as_float(v)
+		return protoreflect.ValueOfFloat32(float32(x)), err
+	case protoreflect.DoubleKind:
+		x, err := as_float(v)
+		return protoreflect.ValueOfFloat64(x), err
+	case protoreflect.StringKind:
+		if v.Kind() != reflect.String {
+			return protoreflect.Value{}, fmt.Errorf("cannot encode %v as a string", v.Type())
+		}
+		return protoreflect.ValueOfString(v.String()), nil
+	case protoreflect.BytesKind:
+		x, err := as_bytes(v)
+		return protoreflect.ValueOfBytes(x), err
+	}
+	return protoreflect.Value{}, fmt.Errorf("protobuf type [%v] not implemented", fd.Kind())
+}
+
+func as_int(v reflect.Value) (int64, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return int64(v.Float()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("cannot encode %v as an integer", v.Type())
+}
+
+func as_uint(v reflect.Value) (uint64, error) {
+	x, err := as_int(v)
+	if err != nil {
+		return 0, err
+	}
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), nil
+	}
+	return uint64(x), nil
+}
+
+func as_float(v reflect.Value) (float64, error) {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	}
+	x, err := as_int(v)
+	if err != nil {
+		return 0, fmt.Errorf("cannot encode %v as a floating point", v.Type())
+	}
+	return float64(x), nil
+}
+
+func as_bytes(v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return []byte(v.String()), nil
+	case reflect.Int8, reflect.Uint8:
+		x, _ := as_uint(v)
+		return []byte{byte(x)}, nil
+	case reflect.Slice, reflect.Array:
+		switch v.Type().Elem().Kind() {
+		case reflect.Int8, reflect.Uint8:
+			x := make([]byte, v.Len())
+			for i := range x {
+				xx, _ := as_uint(v.Index(i))
+				x[i] = byte(xx)
+			}
+			return x, nil
+		}
+	}
+	// values of other types (e.g. branches with an unsupported type mapped
+	// to bytes fields) are stored with their binary encoding.
+	x, err := append_bytes(nil, v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode %v as bytes: %v", v.Type(), err)
+	}
+	return x, nil
+}
+
+// append_bytes appends the binary encoding of v to buf:
+//   - booleans and numbers are encoded in little-endian,
+//   - arrays and structs as their elements (or fields), in order,
+//   - strings, slices and maps as their uint32 length followed by their
+//     elements (maps' entries are sorted by the encoding of their keys),
+//   - pointers and interfaces as a 0 (nil) or 1 byte, followed by the value
+//     they point to.
+func append_bytes(buf []byte, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int8, reflect.Uint8:
+		x, _ := as_uint(v)
+		return append(buf, byte(x)), nil
+	case reflect.Int16, reflect.Uint16:
+		x, _ := as_uint(v)
+		return binary.LittleEndian.AppendUint16(buf, uint16(x)), nil
+	case reflect.Int32, reflect.Uint32:
+		x, _ := as_uint(v)
+		return binary.LittleEndian.AppendUint32(buf, uint32(x)), nil
+	case reflect.Int, reflect.Uint, reflect.Int64, reflect.Uint64:
+		x, _ := as_uint(v)
+		return binary.LittleEndian.AppendUint64(buf, x), nil
+	case reflect.Float32:
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(v.Len()))
+		return append(buf, v.String()...), nil
+	case reflect.Slice:
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(v.Len()))
+		fallthrough
+	case reflect.Array:
+		var err error
+		for i := 0; i < v.Len() && err == nil; i++ {
+			buf, err = append_bytes(buf, v.Index(i))
+		}
+		return buf, err
+	case reflect.Struct:
+		var err error
+		for i := 0; i < v.NumField() && err == nil; i++ {
+			buf, err = append_bytes(buf, v.Field(i))
+		}
+		return buf, err
+	case reflect.Map:
+		entries := make([][]byte, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := append_bytes(nil, iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			entry, err := append_bytes(key, iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i], entries[j]) < 0
+		})
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entries)))
+		for _, entry := range entries {
+			buf = append(buf, entry...)
+		}
+		return buf, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		return append_bytes(append(buf, 1), v.Elem())
+	}
+	return nil, fmt.Errorf("unsupported kind %v", v.Kind())
+}
+
+// EOF
@@ -0,0 +1,115 @@
+package root2pb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	pb_descr "google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ErrIncompatible is returned for the changes of a schema which make the
+// files written with its previous version unreadable.
+var ErrIncompatible = errors.New("root2pb: incompatible change")
+
+// LoadDescriptorSet reads the descriptor set generated by protoc in the file
+// fname (e.g. out/descr.pbuf.)
+func LoadDescriptorSet(fname string) (*pb_descr.FileDescriptorSet, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	fdset := &pb_descr.FileDescriptorSet{}
+	err = proto.Unmarshal(data, fdset)
+	if err != nil {
+		return nil, err
+	}
+	return fdset, nil
+}
+
+// CheckCompat compares schema with its previous version, described by the
+// descriptor set fdset, and returns the breaking changes: fields whose type,
+// number or label (repeated/singular) changed, and removed required fields.
+func CheckCompat(fdset *pb_descr.FileDescriptorSet, schema *Schema) []error {
+	old := make(map[string]*pb_descr.DescriptorProto)
+	for _, fd := range fdset.File {
+		if fd.GetPackage() != schema.Package {
+			continue
+		}
+		for _, msg := range fd.MessageType {
+			old[msg.GetName()] = msg
+		}
+	}
+
+	var errs []error
+	if msg, ok := old[schema.Message]; ok {
+		errs = append(errs, check_message(schema.Package, msg, schema.Fields)...)
+	}
+	for _, m := range schema.Messages {
+		if msg, ok := old[m.Name]; ok {
+			errs = append(errs, check_message(schema.Package, msg, m.Fields)...)
+		}
+	}
+	return errs
+}
+
+// check_message returns the breaking changes between the fields of the
+// message descr, from package pkg, and their new version.
+func check_message(pkg string, descr *pb_descr.DescriptorProto, fields []Field) []error {
+	var errs []error
+	incompat := func(field string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%w: %s.%s: %s", ErrIncompatible,
+			descr.GetName(), field, fmt.Sprintf(format, args...)))
+	}
+
+	names := make(map[string]Field, len(fields))
+	ids := make(map[int]Field, len(fields))
+	for _, f := range fields {
+		names[f.Name] = f
+		ids[f.Id] = f
+	}
+
+	for _, fdp := range descr.Field {
+		name := fdp.GetName()
+		f, ok := names[name]
+		if !ok {
+			if fdp.GetLabel() == pb_descr.FieldDescriptorProto_LABEL_REQUIRED {
+				incompat(name, "required field removed")
+			}
+			if f, ok := ids[int(fdp.GetNumber())]; ok {
+				incompat(name, "field number %d reused by field %s", fdp.GetNumber(), f.Name)
+			}
+			continue
+		}
+		if int(fdp.GetNumber()) != f.Id {
+			incompat(name, "field number changed from %d to %d", fdp.GetNumber(), f.Id)
+		}
+		if typ := descr_type_name(pkg, fdp); typ != f.Type {
+			incompat(name, "type changed from %s to %s", typ, f.Type)
+		}
+		repeated := fdp.GetLabel() == pb_descr.FieldDescriptorProto_LABEL_REPEATED
+		switch {
+		case repeated && !f.Repeated:
+			incompat(name, "changed from repeated to singular")
+		case !repeated && f.Repeated:
+			incompat(name, "changed from singular to repeated")
+		}
+	}
+	return errs
+}
+
+// descr_type_name returns the name of the type of a field, as written in
+// the .proto files of package pkg.
+func descr_type_name(pkg string, fdp *pb_descr.FieldDescriptorProto) string {
+	switch fdp.GetType() {
+	case pb_descr.FieldDescriptorProto_TYPE_MESSAGE,
+		pb_descr.FieldDescriptorProto_TYPE_ENUM:
+		name := strings.TrimPrefix(fdp.GetTypeName(), ".")
+		return strings.TrimPrefix(name, pkg+".")
+	}
+	return strings.ToLower(strings.TrimPrefix(fdp.GetType().String(), "TYPE_"))
+}
+
+// EOF
@@ -0,0 +1,214 @@
+package root2pb
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	pb_descr "google.golang.org/protobuf/types/descriptorpb"
+)
+
+func descr_field(name string, id int32, typ pb_descr.FieldDescriptorProto_Type, tname string, label pb_descr.FieldDescriptorProto_Label) *pb_descr.FieldDescriptorProto {
+	fdp := &pb_descr.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(id),
+		Type:   typ.Enum(),
+		Label:  label.Enum(),
+	}
+	if tname != "" {
+		fdp.TypeName = proto.String(tname)
+	}
+	return fdp
+}
+
+// compat_descr returns the descriptor set of the previous version of the
+// event package.
+func compat_descr() *pb_descr.FileDescriptorSet {
+	const (
+		optional = pb_descr.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated = pb_descr.FieldDescriptorProto_LABEL_REPEATED
+		required = pb_descr.FieldDescriptorProto_LABEL_REQUIRED
+	)
+	return &pb_descr.FileDescriptorSet{
+		File: []*pb_descr.FileDescriptorProto{
+			{
+				Name:    proto.String("event.proto"),
+				Package: proto.String("event"),
+				MessageType: []*pb_descr.DescriptorProto{
+					{
+						Name: proto.String("PosBranch"),
+						Field: []*pb_descr.FieldDescriptorProto{
+							descr_field("X", 1, pb_descr.FieldDescriptorProto_TYPE_FLOAT, "", optional),
+							descr_field("Y", 2, pb_descr.FieldDescriptorProto_TYPE_FLOAT, "", optional),
+						},
+					},
+					{
+						Name: proto.String("Event"),
+						Field: []*pb_descr.FieldDescriptorProto{
+							descr_field("Pt", 1, pb_descr.FieldDescriptorProto_TYPE_FLOAT, "", optional),
+							descr_field("Ids", 2, pb_descr.FieldDescriptorProto_TYPE_INT32, "", repeated),
+							descr_field("Hits", 3, pb_descr.FieldDescriptorProto_TYPE_MESSAGE, ".event.FloatList", repeated),
+							descr_field("Run", 4, pb_descr.FieldDescriptorProto_TYPE_INT32, "", required),
+							descr_field("Pos", 5, pb_descr.FieldDescriptorProto_TYPE_MESSAGE, ".event.PosBranch", optional),
+							descr_field("N", 6, pb_descr.FieldDescriptorProto_TYPE_UINT32, "", optional),
+						},
+					},
+				},
+			},
+			{
+				// messages of other packages are not compared.
+				Name:    proto.String("other.proto"),
+				Package: proto.String("other"),
+				MessageType: []*pb_descr.DescriptorProto{
+					{
+						Name: proto.String("Event"),
+						Field: []*pb_descr.FieldDescriptorProto{
+							descr_field("Pt", 1, pb_descr.FieldDescriptorProto_TYPE_DOUBLE, "", optional),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// compat_schema returns a schema compatible with compat_descr.
+func compat_schema() *Schema {
+	return &Schema{
+		Package: "event",
+		Message: "Event",
+		Fields: []Field{
+			{Name: "Pt", Type: "float", Id: 1, Branch: "pt"},
+			{Name: "Ids", Type: "int32", Id: 2, Branch: "ids", Repeated: true},
+			{Name: "Hits", Type: "FloatList", Id: 3, Branch: "hits", Repeated: true},
+			{Name: "Run", Type: "int32", Id: 4, Branch: "run"},
+			{Name: "Pos", Type: "PosBranch", Id: 5, Branch: "pos"},
+			{Name: "E", Type: "double", Id: 7, Branch: "e"},
+		},
+		Messages: []Message{
+			{Name: "FloatList", Fields: []Field{{Name: "v", Type: "float", Id: 1, Repeated: true}}},
+			{Name: "PosBranch", Fields: []Field{
+				{Name: "X", Type: "float", Id: 1, Leaf: "x"},
+				{Name: "Y", Type: "float", Id: 2, Leaf: "y"},
+				{Name: "Z", Type: "float", Id: 3, Leaf: "z"},
+			}},
+		},
+	}
+}
+
+func TestCheckCompat(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(s *Schema)
+		want   []string
+	}{
+		{
+			name:   "compatible",
+			modify: func(s *Schema) {},
+		},
+		{
+			name:   "type",
+			modify: func(s *Schema) { s.Fields[0].Type = "double" },
+			want:   []string{"Event.Pt: type changed from float to double"},
+		},
+		{
+			name:   "message type",
+			modify: func(s *Schema) { s.Fields[2].Type = "DoubleList" },
+			want:   []string{"Event.Hits: type changed from FloatList to DoubleList"},
+		},
+		{
+			name:   "number",
+			modify: func(s *Schema) { s.Fields[0].Id = 8 },
+			want:   []string{"Event.Pt: field number changed from 1 to 8"},
+		},
+		{
+			name:   "singular",
+			modify: func(s *Schema) { s.Fields[1].Repeated = false },
+			want:   []string{"Event.Ids: changed from repeated to singular"},
+		},
+		{
+			name:   "repeated",
+			modify: func(s *Schema) { s.Fields[0].Repeated = true },
+			want:   []string{"Event.Pt: changed from singular to repeated"},
+		},
+		{
+			name: "required removed",
+			modify: func(s *Schema) {
+				s.Fields = append(s.Fields[:3], s.Fields[4:]...)
+			},
+			want: []string{"Event.Run: required field removed"},
+		},
+		{
+			name: "number reused",
+			modify: func(s *Schema) {
+				s.Fields[5].Id = 6
+			},
+			want: []string{"Event.N: field number 6 reused by field E"},
+		},
+		{
+			name:   "sub-message",
+			modify: func(s *Schema) { s.Messages[1].Fields[1].Type = "int32" },
+			want:   []string{"PosBranch.Y: type changed from float to int32"},
+		},
+		{
+			name: "several",
+			modify: func(s *Schema) {
+				s.Fields[0].Type = "double"
+				s.Fields[0].Repeated = true
+				s.Messages[1].Fields[0].Id = 4
+			},
+			want: []string{
+				"Event.Pt: type changed from float to double",
+				"Event.Pt: changed from singular to repeated",
+				"PosBranch.X: field number changed from 1 to 4",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := compat_schema()
+			tc.modify(s)
+			errs := CheckCompat(compat_descr(), s)
+			if len(errs) != len(tc.want) {
+				t.Fatalf("invalid number of errors: got %d, want %d: %v", len(errs), len(tc.want), errs)
+			}
+			for i, err := range errs {
+				if !errors.Is(err, ErrIncompatible) {
+					t.Fatalf("error %d: got %v, want ErrIncompatible", i, err)
+				}
+				if !strings.HasSuffix(err.Error(), tc.want[i]) {
+					t.Fatalf("error %d: got %q, want %q", i, err.Error(), tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadDescriptorSet(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "descr.pbuf")
+	data, err := proto.Marshal(compat_descr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(fname, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fdset, err := LoadDescriptorSet(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(fdset, compat_descr()) {
+		t.Fatalf("descriptor set round trip failed")
+	}
+
+	_, err = LoadDescriptorSet(filepath.Join(t.TempDir(), "missing.pbuf"))
+	if err == nil {
+		t.Fatalf("expected an error for a missing file")
+	}
+}
+
+// EOF
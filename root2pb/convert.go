@@ -0,0 +1,397 @@
+package root2pb
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/sbinet/go-root2pb/pbio"
+	"github.com/sbinet/go-root2pb/pbutils"
+	"github.com/sbinet/go-root2pb/rootutils"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ConvertFile converts the content of the tree treename from the ROOT file
+// filename into the .pbuf file oname, building each entry's message
+// dynamically out of the descriptors stored in descr_fname.
+func ConvertFile(filename, treename, descr_fname, oname string) error {
+	fdset, err := LoadDescriptorSet(descr_fname)
+	if err != nil {
+		return err
+	}
+
+	// check the event message can be filled before writing anything.
+	descr, err := EventDescriptor(fdset)
+	if err != nil {
+		return err
+	}
+	err = check_event(descr)
+	if err != nil {
+		return err
+	}
+
+	tree, err := rootutils.Open(filename, treename)
+	if err != nil {
+		return err
+	}
+	defer tree.Close()
+
+	out, err := pbio.Create(oname)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	err = out.WriteHeader(&pbio.DataHeader{ProtoFiles: fdset})
+	if err != nil {
+		return err
+	}
+
+	err = WriteTree(out, tree, fdset)
+	if err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+// WriteTree writes the entries of a tree as the messages described by the
+// event message of fdset.
+func WriteTree(out *pbio.Writer, tree rootutils.TreeSource, fdset *descriptorpb.FileDescriptorSet) error {
+	descr, err := EventDescriptor(fdset)
+	if err != nil {
+		return err
+	}
+
+	evt := dynamicpb.NewMessage(descr)
+	return ReadTree(tree, evt, func(ievt int64) error {
+		return out.WriteEvent(evt)
+	})
+}
+
+// ReadTree reads the entries of a tree into the message evt, generated or
+// dynamic, whose fields are bound to branches by their (root_branch)
+// option, calling f after each entry.
+func ReadTree(tree rootutils.TreeSource, evt protoreflect.Message, f func(ievt int64) error) error {
+	descr := evt.Descriptor()
+	err := check_event(descr)
+	if err != nil {
+		return err
+	}
+	values := pbutils.NewMessage(descr)
+	opts := new_root_options(descr.ParentFile())
+
+	// sets[i] stores the i-th value read from the tree into values.
+	vars := []rootutils.ReadVar{}
+	sets := []func(v reflect.Value){}
+	fields := descr.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		i := i
+		field := fields.Get(i)
+		branch := opts.branch_name(field)
+		sub := field.Message()
+		if !opts.has_leaves(sub) {
+			vars = append(vars, rootutils.ReadVar{Branch: branch})
+			sets = append(sets, func(v reflect.Value) { values.Values[i] = v })
+			continue
+		}
+		sfields := sub.Fields()
+		if field.IsList() {
+			// collection of split objects: one sub-message per object
+			objs := &collection{descr: sub}
+			values.Values[i] = reflect.ValueOf(&objs.msgs)
+			for j := 0; j < sfields.Len(); j++ {
+				j := j
+				member := opts.leaf_name(sfields.Get(j))
+				vars = append(vars, rootutils.ReadVar{Branch: branch, Leaf: member})
+				sets = append(sets, func(v reflect.Value) { objs.set(j, v) })
+			}
+			continue
+		}
+		// multi-leaf branch or split object: one sub-message field per leaf
+		msg := pbutils.NewMessage(sub)
+		values.Values[i] = reflect.ValueOf(msg)
+		for j := 0; j < sfields.Len(); j++ {
+			j := j
+			leaf := opts.leaf_name(sfields.Get(j))
+			vars = append(vars, rootutils.ReadVar{Branch: branch, Leaf: leaf})
+			sets = append(sets, func(v reflect.Value) { msg.Values[j] = v })
+		}
+	}
+
+	return tree.Read(vars, func(ievt int64, vals []reflect.Value) error {
+		for i, v := range vals {
+			sets[i](v)
+		}
+		err := values.Fill(evt)
+		if err == nil {
+			err = f(ievt)
+		}
+		if err != nil {
+			return fmt.Errorf("root2pb: entry-#%v: %w", ievt, err)
+		}
+		return nil
+	})
+}
+
+// check_event checks the fields of the event message descr can be filled
+// with the values read from a tree: the values of branches and leaves can
+// only be stored into scalar fields, or into messages with a single field
+// (e.g. the wrappers of nested vectors.)
+// Message types with several fields not bound to leaves, such as
+// google.protobuf.Timestamp mapped by a user type map, are rejected.
+func check_event(descr protoreflect.MessageDescriptor) error {
+	opts := new_root_options(descr.ParentFile())
+	fields := descr.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		sub := field.Message()
+		if !opts.has_leaves(sub) {
+			err := check_field(field)
+			if err != nil {
+				return fmt.Errorf("root2pb: branch [%s]: %w", opts.branch_name(field), err)
+			}
+			continue
+		}
+		sfields := sub.Fields()
+		for j := 0; j < sfields.Len(); j++ {
+			err := check_field(sfields.Get(j))
+			if err != nil {
+				return fmt.Errorf("root2pb: branch [%s], leaf [%s]: %w",
+					opts.branch_name(field), opts.leaf_name(sfields.Get(j)), err)
+			}
+		}
+	}
+	return nil
+}
+
+// check_field checks the field fd can hold the value of a branch or leaf.
+func check_field(fd protoreflect.FieldDescriptor) error {
+	for msg := fd.Message(); msg != nil; msg = msg.Fields().Get(0).Message() {
+		if msg.Fields().Len() != 1 {
+			return fmt.Errorf("field [%s]: cannot convert a ROOT value into a [%s] message "+
+				"(only scalars and messages with a single field are supported)",
+				fd.Name(), msg.FullName())
+		}
+	}
+	return nil
+}
+
+// collection holds the messages encoding a collection of split objects.
+type collection struct {
+	descr protoreflect.MessageDescriptor
+	msgs  []*pbutils.Message
+}
+
+// set sets the j-th field of the messages with the values v of the
+// corresponding data member, one per object of the collection.
+func (c *collection) set(j int, v reflect.Value) {
+	n := v.Len()
+	for len(c.msgs) < n {
+		c.msgs = append(c.msgs, pbutils.NewMessage(c.descr))
+	}
+	c.msgs = c.msgs[:n]
+	for k, msg := range c.msgs {
+		msg.Values[j] = v.Index(k)
+	}
+}
+
+// new_files builds the descriptors of the .proto files of fdset, sorted
+// by dependencies as protoc writes them.
+// The linked-in descriptors of the google.protobuf files are reused, so the
+// (root_branch) and (root_leaf) options extend the very FieldOptions message
+// the options of the fields are decoded into.
+func new_files(fdset *descriptorpb.FileDescriptorSet) (*protoregistry.Files, error) {
+	files := new(protoregistry.Files)
+	for _, fdp := range fdset.File {
+		if fdp.GetPackage() == "google.protobuf" {
+			fd, err := protoregistry.GlobalFiles.FindFileByPath(fdp.GetName())
+			if err == nil {
+				err = files.RegisterFile(fd)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		fd, err := protodesc.NewFile(fdp, files)
+		if err != nil {
+			return nil, err
+		}
+		err = files.RegisterFile(fd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// root_options decodes the (root_branch) and (root_leaf) options of the
+// fields of the messages of a .proto file.
+type root_options struct {
+	branch protoreflect.ExtensionType
+	leaf   protoreflect.ExtensionType
+}
+
+// new_root_options returns the decoder of the options declared by the
+// .proto file fd or by one of its imports.
+// The registered extension types are used if the generated Go package is
+// linked in, dynamic ones otherwise.
+func new_root_options(fd protoreflect.FileDescriptor) *root_options {
+	o := &root_options{}
+	o.find(fd, make(map[string]bool))
+	return o
+}
+
+func (o *root_options) find(fd protoreflect.FileDescriptor, seen map[string]bool) {
+	if seen[fd.Path()] {
+		return
+	}
+	seen[fd.Path()] = true
+
+	xds := fd.Extensions()
+	for i := 0; i < xds.Len(); i++ {
+		xd := xds.Get(i)
+		if xd.ContainingMessage().FullName() != "google.protobuf.FieldOptions" ||
+			xd.Kind() != protoreflect.StringKind {
+			continue
+		}
+		xt, err := protoregistry.GlobalTypes.FindExtensionByName(xd.FullName())
+		if err != nil {
+			xt = dynamicpb.NewExtensionType(xd)
+		}
+		switch xd.Name() {
+		case "root_branch":
+			if o.branch == nil {
+				o.branch = xt
+			}
+		case "root_leaf":
+			if o.leaf == nil {
+				o.leaf = xt
+			}
+		}
+	}
+
+	imps := fd.Imports()
+	for i := 0; i < imps.Len(); i++ {
+		o.find(imps.Get(i).FileDescriptor, seen)
+	}
+}
+
+// FindExtensionByName implements protoregistry.ExtensionTypeResolver.
+func (o *root_options) FindExtensionByName(name protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	for _, xt := range []protoreflect.ExtensionType{o.branch, o.leaf} {
+		if xt != nil && xt.TypeDescriptor().FullName() == name {
+			return xt, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+// FindExtensionByNumber implements protoregistry.ExtensionTypeResolver.
+func (o *root_options) FindExtensionByNumber(msg protoreflect.FullName, num protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	for _, xt := range []protoreflect.ExtensionType{o.branch, o.leaf} {
+		if xt != nil && xt.TypeDescriptor().ContainingMessage().FullName() == msg &&
+			xt.TypeDescriptor().Number() == num {
+			return xt, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+// has_leaves returns whether the fields of the message msg are bound to
+// leaves or data members, ie: msg encodes a multi-leaf branch or split
+// objects.
+func (o *root_options) has_leaves(msg protoreflect.MessageDescriptor) bool {
+	return msg != nil && msg.Fields().Len() > 0 && o.leaf_name(msg.Fields().Get(0)) != ""
+}
+
+// branch_name returns the name of the ROOT branch a field has been
+// generated from, as recorded in its (root_branch) option.
+func (o *root_options) branch_name(field protoreflect.FieldDescriptor) string {
+	return o.get(field, o.branch)
+}
+
+// leaf_name returns the name of the ROOT leaf (or data member) a field of a
+// multi-leaf branch (or split object) message has been generated from, as
+// recorded in its (root_leaf) option.
+func (o *root_options) leaf_name(field protoreflect.FieldDescriptor) string {
+	return o.get(field, o.leaf)
+}
+
+// get returns the value of the string option xt of field.
+func (o *root_options) get(field protoreflect.FieldDescriptor, xt protoreflect.ExtensionType) string {
+	if xt == nil {
+		return ""
+	}
+	opts, ok := field.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return ""
+	}
+	if !proto.HasExtension(opts, xt) && len(opts.ProtoReflect().GetUnknown()) > 0 {
+		// options of descriptors built out of a descriptor set are decoded
+		// before the extensions they use are known: decode them again.
+		data, err := proto.Marshal(opts)
+		if err != nil {
+			return ""
+		}
+		opts = &descriptorpb.FieldOptions{}
+		err = proto.UnmarshalOptions{Resolver: o}.Unmarshal(data, opts)
+		if err != nil {
+			return ""
+		}
+	}
+	if !proto.HasExtension(opts, xt) {
+		return ""
+	}
+	v, _ := proto.GetExtension(opts, xt).(string)
+	return v
+}
+
+// EventDescriptor returns the descriptor of the message of fdset encoding
+// the entries of a tree, ie: the message whose fields are bound to branches
+// by their (root_branch) option.
+func EventDescriptor(fdset *descriptorpb.FileDescriptorSet) (protoreflect.MessageDescriptor, error) {
+	files, err := new_files(fdset)
+	if err != nil {
+		return nil, fmt.Errorf("root2pb: invalid descriptor set: %v", err)
+	}
+	descr := get_event_descr(fdset, files)
+	if descr == nil {
+		return nil, fmt.Errorf("root2pb: no event message in descriptor set")
+	}
+	return descr, nil
+}
+
+func get_event_descr(fdset *descriptorpb.FileDescriptorSet, files *protoregistry.Files) protoreflect.MessageDescriptor {
+	for _, fdp := range fdset.File {
+		if fdp.GetPackage() == "google.protobuf" {
+			continue
+		}
+		fd, err := files.FindFileByPath(fdp.GetName())
+		if err != nil {
+			continue
+		}
+		opts := new_root_options(fd)
+		msgs := fd.Messages()
+		for i := 0; i < msgs.Len(); i++ {
+			msg := msgs.Get(i)
+			if msg.Name() == "DataHeader" {
+				continue
+			}
+			fields := msg.Fields()
+			for j := 0; j < fields.Len(); j++ {
+				if opts.branch_name(fields.Get(j)) != "" {
+					return msg
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// EOF
@@ -0,0 +1,381 @@
+package root2pb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	pb_descr "google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/sbinet/go-root2pb/rootutils"
+)
+
+// numbers of the (root_branch) and (root_leaf) options.
+const (
+	root_branch_ext = 50002
+	root_leaf_ext   = 50003
+)
+
+// with_option returns fdp with the string option ext set to v, encoded as
+// protoc does in the descriptor sets it writes.
+func with_option(fdp *pb_descr.FieldDescriptorProto, ext protowire.Number, v string) *pb_descr.FieldDescriptorProto {
+	fdp.Options = &pb_descr.FieldOptions{}
+	fdp.Options.ProtoReflect().SetUnknown(
+		protowire.AppendString(protowire.AppendTag(nil, ext, protowire.BytesType), v),
+	)
+	return fdp
+}
+
+// new_fdset returns the descriptor set of the .proto file fdp, declaring
+// the (root_branch) and (root_leaf) options, and of its imports.
+func new_fdset(fdp *pb_descr.FileDescriptorProto, imports ...protoreflect.FileDescriptor) *pb_descr.FileDescriptorSet {
+	option := func(name string, id int32) *pb_descr.FieldDescriptorProto {
+		return &pb_descr.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(id),
+			Type:     pb_descr.FieldDescriptorProto_TYPE_STRING.Enum(),
+			Label:    pb_descr.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Extendee: proto.String(".google.protobuf.FieldOptions"),
+		}
+	}
+	fdset := &pb_descr.FileDescriptorSet{}
+	imports = append([]protoreflect.FileDescriptor{pb_descr.File_google_protobuf_descriptor_proto}, imports...)
+	for _, fd := range imports {
+		fdp.Dependency = append(fdp.Dependency, fd.Path())
+		fdset.File = append(fdset.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	fdp.Extension = []*pb_descr.FieldDescriptorProto{
+		option("root_branch", root_branch_ext),
+		option("root_leaf", root_leaf_ext),
+	}
+	fdset.File = append(fdset.File, fdp)
+	return fdset
+}
+
+func TestEventDescriptor(t *testing.T) {
+	const optional = pb_descr.FieldDescriptorProto_LABEL_OPTIONAL
+	// messages added after the event one (e.g. by a user template) are not
+	// bound to branches.
+	fdset := new_fdset(&pb_descr.FileDescriptorProto{
+		Name:    proto.String("event.proto"),
+		Package: proto.String("event"),
+		MessageType: []*pb_descr.DescriptorProto{
+			{
+				Name: proto.String("PosBranch"),
+				Field: []*pb_descr.FieldDescriptorProto{
+					with_option(descr_field("X", 1, pb_descr.FieldDescriptorProto_TYPE_FLOAT, "", optional), root_leaf_ext, "x"),
+				},
+			},
+			{
+				Name: proto.String("MyEvent"),
+				Field: []*pb_descr.FieldDescriptorProto{
+					with_option(descr_field("Pos", 1, pb_descr.FieldDescriptorProto_TYPE_MESSAGE, ".event.PosBranch", optional), root_branch_ext, "pos"),
+				},
+			},
+			{
+				Name: proto.String("DataHeader"),
+				Field: []*pb_descr.FieldDescriptorProto{
+					descr_field("nevts", 2, pb_descr.FieldDescriptorProto_TYPE_UINT64, "", optional),
+				},
+			},
+			{
+				Name: proto.String("RunInfo"),
+				Field: []*pb_descr.FieldDescriptorProto{
+					descr_field("run", 1, pb_descr.FieldDescriptorProto_TYPE_UINT32, "", optional),
+				},
+			},
+		},
+	})
+
+	descr, err := EventDescriptor(fdset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := descr.FullName(), "event.MyEvent"; string(got) != want {
+		t.Fatalf("invalid event message: got %s, want %s", got, want)
+	}
+
+	// no message bound to branches.
+	fdset.File[1].MessageType = fdset.File[1].MessageType[2:]
+	_, err = EventDescriptor(fdset)
+	if err == nil {
+		t.Fatalf("expected an error for a descriptor set without event message")
+	}
+}
+
+// wkt_fdset returns the descriptor set of an event message whose ts and
+// id branches are mapped to the ts_type and id_type messages.
+func wkt_fdset(ts_type, id_type string) *pb_descr.FileDescriptorSet {
+	const optional = pb_descr.FieldDescriptorProto_LABEL_OPTIONAL
+	return new_fdset(&pb_descr.FileDescriptorProto{
+		Name:    proto.String("event.proto"),
+		Package: proto.String("event"),
+		MessageType: []*pb_descr.DescriptorProto{
+			{
+				Name: proto.String("Event"),
+				Field: []*pb_descr.FieldDescriptorProto{
+					with_option(descr_field("N", 1, pb_descr.FieldDescriptorProto_TYPE_INT32, "", optional), root_branch_ext, "n"),
+					with_option(descr_field("Ts", 2, pb_descr.FieldDescriptorProto_TYPE_MESSAGE, ts_type, optional), root_branch_ext, "ts"),
+					with_option(descr_field("Id", 3, pb_descr.FieldDescriptorProto_TYPE_MESSAGE, id_type, optional), root_branch_ext, "id"),
+				},
+			},
+		},
+	},
+		timestamppb.File_google_protobuf_timestamp_proto,
+		wrapperspb.File_google_protobuf_wrappers_proto,
+	)
+}
+
+func TestReadTreeMessageTypes(t *testing.T) {
+	tree := new_tree(t,
+		mem_branch{rootutils.Branch{Name: "n"}, []int32{1, 2}},
+		mem_branch{rootutils.Branch{Name: "ts", TypeName: "TTimeStamp"}, []int64{10, 20}},
+		mem_branch{rootutils.Branch{Name: "id", TypeName: "ULong64_t"}, []uint64{100, 200}},
+	)
+
+	// single-field messages (e.g. wrappers) are filled with the value of the branch.
+	descr, err := EventDescriptor(wkt_fdset(".google.protobuf.Int64Value", ".google.protobuf.UInt64Value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt := dynamicpb.NewMessage(descr)
+	var ids []uint64
+	err = ReadTree(tree, evt, func(ievt int64) error {
+		fields := descr.Fields()
+		ts := evt.Get(fields.ByName("Ts")).Message()
+		if got, want := ts.Get(ts.Descriptor().Fields().Get(0)).Int(), 10*(ievt+1); got != want {
+			t.Fatalf("entry %d: invalid ts: got %d, want %d", ievt, got, want)
+		}
+		id := evt.Get(fields.ByName("Id")).Message()
+		ids = append(ids, id.Get(id.Descriptor().Fields().Get(0)).Uint())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != 100 || ids[1] != 200 {
+		t.Fatalf("invalid ids: %v", ids)
+	}
+
+	// messages with several fields are rejected before any entry is read.
+	descr, err = EventDescriptor(wkt_fdset(".google.protobuf.Timestamp", ".google.protobuf.UInt64Value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nevts := 0
+	err = ReadTree(tree, dynamicpb.NewMessage(descr), func(ievt int64) error {
+		nevts++
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected an error converting a google.protobuf.Timestamp")
+	}
+	if !strings.Contains(err.Error(), "[ts]") || !strings.Contains(err.Error(), "google.protobuf.Timestamp") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if nevts != 0 {
+		t.Fatalf("%d entries converted before the error", nevts)
+	}
+}
+
+func TestConvertFileMessageTypes(t *testing.T) {
+	dir := t.TempDir()
+	descr_fname := filepath.Join(dir, "descr.pbuf")
+	data, err := proto.Marshal(wkt_fdset(".google.protobuf.Timestamp", ".google.protobuf.UInt64Value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(descr_fname, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the schema is checked before the ROOT file is opened and the output
+	// file created.
+	oname := filepath.Join(dir, "data.pbuf")
+	err = ConvertFile(filepath.Join(dir, "missing.root"), "tree", descr_fname, oname)
+	if err == nil || errors.Is(err, ErrFileNotFound) || !strings.Contains(err.Error(), "google.protobuf.Timestamp") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = os.Stat(oname)
+	if !os.IsNotExist(err) {
+		t.Fatalf("output file created: %v", err)
+	}
+}
+
+// schema_fdset returns the descriptor set of the .proto file protoc
+// generates for schema.
+func schema_fdset(schema *Schema) *pb_descr.FileDescriptorSet {
+	fields := func(fields []Field) []*pb_descr.FieldDescriptorProto {
+		fdps := make([]*pb_descr.FieldDescriptorProto, 0, len(fields))
+		for _, f := range fields {
+			label := pb_descr.FieldDescriptorProto_LABEL_OPTIONAL
+			if f.Repeated {
+				label = pb_descr.FieldDescriptorProto_LABEL_REPEATED
+			}
+			typ, tname := pb_descr.FieldDescriptorProto_TYPE_MESSAGE, "."+schema.Package+"."+f.Type
+			if pb_scalars[f.Type] {
+				typ = pb_descr.FieldDescriptorProto_Type(pb_descr.FieldDescriptorProto_Type_value["TYPE_"+strings.ToUpper(f.Type)])
+				tname = ""
+			}
+			fdp := descr_field(f.Name, int32(f.Id), typ, tname, label)
+			switch {
+			case f.Branch != "":
+				fdp = with_option(fdp, root_branch_ext, f.Branch)
+			case f.Leaf != "":
+				fdp = with_option(fdp, root_leaf_ext, f.Leaf)
+			}
+			fdps = append(fdps, fdp)
+		}
+		return fdps
+	}
+	fdp := &pb_descr.FileDescriptorProto{
+		Name:    proto.String("event.proto"),
+		Package: proto.String(schema.Package),
+	}
+	for _, msg := range schema.Messages {
+		fdp.MessageType = append(fdp.MessageType, &pb_descr.DescriptorProto{
+			Name:  proto.String(msg.Name),
+			Field: fields(msg.Fields),
+		})
+	}
+	fdp.MessageType = append(fdp.MessageType, &pb_descr.DescriptorProto{
+		Name:  proto.String(schema.Message),
+		Field: fields(schema.Fields),
+	})
+	return new_fdset(fdp)
+}
+
+func TestReadTreeFile(t *testing.T) {
+	tree, err := rootutils.Open("../rootutils/testdata/small-flat-tree.root", "tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	schema := infer(t, tree, Options{Selection: "Str,ArrayInt32,N,SliceInt32"})
+	check_fields(t, "Event", schema.Fields, []Field{
+		{Name: "Str", Type: "string", Id: 1, Branch: "Str"},
+		{Name: "ArrayInt32", Type: "int32", Id: 2, Branch: "ArrayInt32", Repeated: true},
+		{Name: "N", Type: "int32", Id: 3, Branch: "N"},
+		{Name: "SliceInt32", Type: "int32", Id: 4, Branch: "SliceInt32", Repeated: true},
+	})
+
+	descr, err := EventDescriptor(schema_fdset(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt := dynamicpb.NewMessage(descr)
+	fields := descr.Fields()
+	nevts := 0
+	err = ReadTree(tree, evt, func(ievt int64) error {
+		nevts++
+		if got, want := evt.Get(fields.ByName("Str")).String(), fmt.Sprintf("evt-%03d", ievt); got != want {
+			t.Fatalf("entry %d: invalid Str: got %q, want %q", ievt, got, want)
+		}
+		arr := evt.Get(fields.ByName("ArrayInt32")).List()
+		if arr.Len() != 10 || arr.Get(9).Int() != ievt {
+			t.Fatalf("entry %d: invalid ArrayInt32", ievt)
+		}
+		n := evt.Get(fields.ByName("N")).Int()
+		if got := evt.Get(fields.ByName("SliceInt32")).List().Len(); int64(got) != n {
+			t.Fatalf("entry %d: invalid SliceInt32 length: got %d, want %d", ievt, got, n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nevts != 100 {
+		t.Fatalf("invalid number of entries: got %d, want 100", nevts)
+	}
+}
+
+func TestReadTreeNameClash(t *testing.T) {
+	tree, err := rootutils.Open("../rootutils/testdata/sample-6.14.00-zlib.root", "sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	// the ab and Ab branches both map to the Ab field name.
+	schema := infer(t, tree, Options{Selection: "n,ab,Ab"})
+	check_fields(t, "Event", schema.Fields, []Field{
+		{Name: "N", Type: "int32", Id: 1, Branch: "n"},
+		{Name: "Ab", Type: "bool", Id: 2, Branch: "ab", Repeated: true},
+		{Name: "Ab_2", Type: "bool", Id: 3, Branch: "Ab", Repeated: true},
+	})
+
+	descr, err := EventDescriptor(schema_fdset(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt := dynamicpb.NewMessage(descr)
+	fields := descr.Fields()
+	err = ReadTree(tree, evt, func(ievt int64) error {
+		if got := evt.Get(fields.ByName("Ab")).List().Len(); got != 3 {
+			t.Fatalf("entry %d: invalid ab length: got %d, want 3", ievt, got)
+		}
+		n := evt.Get(fields.ByName("N")).Int()
+		if got := evt.Get(fields.ByName("Ab_2")).List().Len(); int64(got) != n {
+			t.Fatalf("entry %d: invalid Ab length: got %d, want %d", ievt, got, n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadTreeBytes(t *testing.T) {
+	for _, fname := range []string{"small-evnt-tree-nosplit.root", "small-evnt-tree-fullsplit.root"} {
+		t.Run(fname, func(t *testing.T) {
+			tree, err := rootutils.Open("../rootutils/testdata/"+fname, "tree")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+
+			// the Event objects hold strings, nested objects and
+			// variable length arrays.
+			schema := infer(t, tree, Options{OnUnsupported: "bytes"})
+			check_fields(t, "Event", schema.Fields, []Field{
+				{Name: "Evt", Type: "bytes", Id: 1, Branch: "evt"},
+			})
+			descr, err := EventDescriptor(schema_fdset(schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			evt := dynamicpb.NewMessage(descr)
+			nevts := 0
+			err = ReadTree(tree, evt, func(ievt int64) error {
+				nevts++
+				// the Event starts with its Beg string, prefixed with its length.
+				data := evt.Get(descr.Fields().Get(0)).Bytes()
+				if want := fmt.Sprintf("\x07\x00\x00\x00beg-%03d", ievt); !strings.HasPrefix(string(data), want) {
+					t.Fatalf("entry %d: invalid bytes: %q", ievt, data)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nevts != 100 {
+				t.Fatalf("invalid number of entries: got %d, want 100", nevts)
+			}
+		})
+	}
+}
+
+// EOF
@@ -0,0 +1,288 @@
+package root2pb
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/sbinet/go-root2pb/rootutils"
+)
+
+// Options configures the generation of a protobuf schema out of a tree.
+type Options struct {
+	Package string // name of the protobuf package (default: "event")
+	Message string // name of the message encoding a tree entry (default: "Event")
+
+	// Selection is a comma-separated list of glob-patterns to select
+	// (with +foo*) and remove (with -foo*) branches.
+	// An empty Selection selects all branches.
+	Selection string
+
+	// OnUnsupported tells what to do with the branches whose type has no
+	// protobuf equivalent:
+	//  - "error" (default): fail with ErrUnsupportedType,
+	//  - "skip": leave them out of the schema (see Schema.Skipped),
+	//  - "bytes": map them to bytes fields.
+	OnUnsupported string
+
+	// TypeMap extends or overrides the default mapping of ROOT types to
+	// protobuf types.
+	TypeMap TypeMap
+
+	// Syntax is the syntax of the generated .proto files: "proto2"
+	// (default) or "proto3".
+	Syntax string
+
+	// Lock, if not nil, keeps the field numbers of the branches stable
+	// across schema regenerations. It is updated with the numbers of the
+	// new and removed branches.
+	Lock *FieldLock
+}
+
+// InferSchema returns the protobuf schema describing the branches of a tree
+// selected by opts.
+func InferSchema(tree rootutils.TreeSource, opts Options) (*Schema, error) {
+	schema := &Schema{
+		Package: opts.Package,
+		Message: opts.Message,
+		Fields:  []Field{},
+		typemap: opts.TypeMap,
+	}
+	if schema.Package == "" {
+		schema.Package = "event"
+	}
+	if schema.Message == "" {
+		schema.Message = "Event"
+	}
+	switch opts.Syntax {
+	case "", "proto2":
+		schema.Syntax = "proto2"
+	case "proto3":
+		schema.Syntax = "proto3"
+	default:
+		return nil, fmt.Errorf("root2pb: invalid syntax [%s]", opts.Syntax)
+	}
+	switch opts.OnUnsupported {
+	case "", "error", "skip", "bytes":
+	default:
+		return nil, fmt.Errorf("root2pb: invalid unsupported types policy [%s]", opts.OnUnsupported)
+	}
+
+	for _, br := range tree.Branches() {
+		name := br.Name
+		if !accept_branch(opts.Selection, name) {
+			continue
+		}
+		pb_type, isrepeated, err := schema.field_type(br)
+		if err != nil && errors.Is(err, ErrUnsupportedType) {
+			switch opts.OnUnsupported {
+			case "skip":
+				schema.Skipped = append(schema.Skipped, br)
+				continue
+			case "bytes":
+				pb_type, isrepeated, err = "bytes", false, nil
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("branch [%s]: %w", name, err)
+		}
+		schema.Fields = append(schema.Fields,
+			Field{
+				Name:     unique_name(camel_case(name), field_names(schema.Fields)),
+				Type:     pb_type,
+				Id:       len(schema.Fields) + 1,
+				Branch:   name,
+				Repeated: isrepeated,
+			})
+	}
+
+	if opts.Lock != nil {
+		opts.Lock.apply(schema)
+	}
+
+	return schema, nil
+}
+
+// field_type returns the protobuf type of the field encoding the branch br,
+// and whether it is a repeated one.
+func (s *Schema) field_type(br rootutils.Branch) (string, bool, error) {
+	if len(br.Branches) > 0 {
+		// split objects and collections of split objects (TClonesArray)
+		pb_type, err := s.add_class(br)
+		return pb_type, br.Collection, err
+	}
+	if len(br.Leaves) > 1 {
+		// multi-leaf branches (x/F:y/F:z/F)
+		pb_type, err := s.add_branch(br)
+		return pb_type, false, err
+	}
+	if len(br.Leaves) == 1 && br.Leaves[0].IsArray() {
+		return s.leaf_type(br.Leaves[0])
+	}
+	return s.pb_type(br.TypeName)
+}
+
+// leaf_type returns the protobuf type of the field encoding the leaf,
+// and whether it is a repeated one.
+func (s *Schema) leaf_type(leaf rootutils.Leaf) (string, bool, error) {
+	pb_type, isrepeated, err := s.pb_type(leaf.TypeName)
+	if err != nil {
+		return "", false, err
+	}
+	if !leaf.IsArray() {
+		return pb_type, isrepeated, nil
+	}
+	// C-style arrays (x[10]/F, px[nPart]/F)
+	if isrepeated {
+		pb_type = s.add_list(pb_type)
+	}
+	return pb_type, true, nil
+}
+
+// add_branch adds to the schema a message with one field per leaf of the
+// multi-leaf branch br, and returns its name.
+func (s *Schema) add_branch(br rootutils.Branch) (string, error) {
+	names := make([]string, 0, len(s.Messages)+1)
+	names = append(names, s.Message)
+	for _, m := range s.Messages {
+		names = append(names, m.Name)
+	}
+	msg := Message{
+		Name:   unique_name(camel_case(br.Name)+"Branch", names),
+		Fields: make([]Field, 0, len(br.Leaves)),
+	}
+	for _, leaf := range br.Leaves {
+		pb_type, isrepeated, err := s.leaf_type(leaf)
+		if err != nil {
+			return "", fmt.Errorf("leaf [%s]: %w", leaf.Name, err)
+		}
+		msg.Fields = append(msg.Fields,
+			Field{
+				Name:     unique_name(camel_case(leaf.Name), field_names(msg.Fields)),
+				Type:     pb_type,
+				Id:       len(msg.Fields) + 1,
+				Leaf:     leaf.Name,
+				Repeated: isrepeated,
+			})
+	}
+	s.Messages = append(s.Messages, msg)
+	return msg.Name, nil
+}
+
+// add_class adds to the schema a message with one field per data member of
+// the split objects held by the branch br, and returns its name.
+// The message is named after the class of the objects, or after the branch
+// when the class is not known.
+// Branches of the same class share the same message: their data members
+// must then be the same.
+func (s *Schema) add_class(br rootutils.Branch) (string, error) {
+	name := camel_case(strings.Replace(br.TypeName, "::", "_", -1))
+	if br.TypeName == "" {
+		name = camel_case(br.Name) + "Object"
+	}
+	msg := Message{
+		Name:   name,
+		Fields: make([]Field, 0, len(br.Branches)),
+	}
+	for _, sub := range br.Branches {
+		if len(sub.Branches) > 0 || len(sub.Leaves) != 1 {
+			return "", fmt.Errorf("%w: data member [%s] (nested split object)",
+				ErrUnsupportedType, sub.Name)
+		}
+		leaf := sub.Leaves[0]
+		if br.Collection {
+			// the counter of the data members is the size of the collection.
+			leaf.Count = ""
+		}
+		pb_type, isrepeated, err := s.leaf_type(leaf)
+		if err != nil {
+			return "", fmt.Errorf("data member [%s]: %w", sub.Name, err)
+		}
+		msg.Fields = append(msg.Fields,
+			Field{
+				Name:     unique_name(camel_case(sub.Name), field_names(msg.Fields)),
+				Type:     pb_type,
+				Id:       len(msg.Fields) + 1,
+				Leaf:     sub.Name,
+				Repeated: isrepeated,
+			})
+	}
+	if name == s.Message || name == "DataHeader" {
+		return "", fmt.Errorf("root2pb: message [%s] of class [%s] clashes with a generated message",
+			name, br.TypeName)
+	}
+	for _, m := range s.Messages {
+		if m.Name != name {
+			continue
+		}
+		if !reflect.DeepEqual(m.Fields, msg.Fields) {
+			return "", fmt.Errorf("root2pb: message [%s] already defined with different fields "+
+				"(data members of class [%s] differ between branches)", name, br.TypeName)
+		}
+		return name, nil
+	}
+	s.Messages = append(s.Messages, msg)
+	return msg.Name, nil
+}
+
+// unique_name returns name, or name suffixed with _2, _3... when it clashes
+// with one of names.
+// Names only differing by their case or underscores clash, as protoc
+// rejects fields with the same JSON name.
+func unique_name(name string, names []string) string {
+	key := func(name string) string {
+		return strings.ToLower(strings.Replace(name, "_", "", -1))
+	}
+	taken := make(map[string]bool, len(names))
+	for _, n := range names {
+		taken[key(n)] = true
+	}
+	uname := name
+	for i := 2; taken[key(uname)]; i++ {
+		uname = fmt.Sprintf("%s_%d", name, i)
+	}
+	return uname
+}
+
+func field_names(fields []Field) []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// accept_branch returns whether the branch name is selected by sel.
+func accept_branch(sel, name string) bool {
+	if sel == "" {
+		return true
+	}
+	accept := false
+	for _, pattern := range strings.Split(sel, ",") {
+		if pattern == "" {
+			continue
+		}
+		switch pattern[0] {
+		case '-':
+			matched, err := filepath.Match(pattern[1:], name)
+			if err == nil && matched {
+				accept = false
+			}
+		case '+':
+			matched, err := filepath.Match(pattern[1:], name)
+			if err == nil && matched {
+				accept = true
+			}
+		default:
+			matched, err := filepath.Match(pattern, name)
+			if err == nil && matched {
+				accept = true
+			}
+		}
+	}
+	return accept
+}
+
+// EOF
@@ -0,0 +1,453 @@
+package root2pb
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/go-root2pb/rootutils"
+)
+
+// mem_branch is a branch of an in-memory tree, with its values.
+type mem_branch struct {
+	br     rootutils.Branch
+	values interface{}
+}
+
+func new_tree(t *testing.T, branches ...mem_branch) *rootutils.MemTree {
+	tree := rootutils.NewMemTree("tree")
+	for _, b := range branches {
+		err := tree.AddBranch(b.br, b.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tree
+}
+
+func infer(t *testing.T, tree rootutils.TreeSource, opts Options) *Schema {
+	schema, err := InferSchema(tree, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func check_fields(t *testing.T, name string, got, want []Field) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: invalid fields:\ngot:  %+v\nwant: %+v", name, got, want)
+	}
+}
+
+func check_messages(t *testing.T, got, want []Message) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("invalid number of messages: got %d, want %d (%+v)", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name {
+			t.Fatalf("message %d: got %s, want %s", i, got[i].Name, want[i].Name)
+		}
+		check_fields(t, want[i].Name, got[i].Fields, want[i].Fields)
+	}
+}
+
+// check_proto checks the .proto file generated for schema holds the lines
+// want.
+func check_proto(t *testing.T, schema *Schema, want ...string) {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	err := GenerateProto(schema, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		lines[strings.TrimSpace(line)] = true
+	}
+	for _, line := range want {
+		if !lines[line] {
+			t.Fatalf("missing line %q in .proto file:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestInferScalars(t *testing.T) {
+	tree := new_tree(t,
+		mem_branch{rootutils.Branch{Name: "run_number"}, []int32{1, 2}},
+		mem_branch{rootutils.Branch{Name: "evt_number"}, []uint64{1, 2}},
+		mem_branch{rootutils.Branch{Name: "ene"}, []float64{1, 2}},
+		mem_branch{rootutils.Branch{Name: "pt"}, []float32{1, 2}},
+		mem_branch{rootutils.Branch{Name: "flag"}, []int8{1, 2}},
+		mem_branch{rootutils.Branch{Name: "ok"}, []bool{true, false}},
+		mem_branch{rootutils.Branch{Name: "name"}, []string{"a", "b"}},
+		mem_branch{rootutils.Branch{Name: "e32", TypeName: "Double32_t"}, []float64{1, 2}},
+	)
+	schema := infer(t, tree, Options{Selection: "*,-evt_*"})
+	if schema.Package != "event" || schema.Message != "Event" || schema.Syntax != "proto2" {
+		t.Fatalf("invalid defaults: %s.%s (%s)", schema.Package, schema.Message, schema.Syntax)
+	}
+	check_fields(t, "Event", schema.Fields, []Field{
+		{Name: "RunNumber", Type: "int32", Id: 1, Branch: "run_number"},
+		{Name: "Ene", Type: "double", Id: 2, Branch: "ene"},
+		{Name: "Pt", Type: "float", Id: 3, Branch: "pt"},
+		{Name: "Flag", Type: "int32", Id: 4, Branch: "flag"},
+		{Name: "Ok", Type: "bool", Id: 5, Branch: "ok"},
+		{Name: "Name", Type: "string", Id: 6, Branch: "name"},
+		{Name: "E32", Type: "double", Id: 7, Branch: "e32"},
+	})
+	check_messages(t, schema.Messages, nil)
+	check_proto(t, schema,
+		"package event;",
+		"message Event {",
+		`optional int32 RunNumber = 1 [(root_branch) = "run_number"];`,
+		`optional string Name = 6 [(root_branch) = "name"];`,
+	)
+
+	schema = infer(t, tree, Options{Selection: "run_*", Syntax: "proto3"})
+	check_proto(t, schema,
+		`syntax = "proto3";`,
+		`int32 RunNumber = 1 [(root_branch) = "run_number"];`,
+	)
+
+	_, err := InferSchema(tree, Options{Syntax: "proto4"})
+	if err == nil {
+		t.Fatalf("expected an error for an invalid syntax")
+	}
+}
+
+func TestInferVectors(t *testing.T) {
+	tree := new_tree(t,
+		mem_branch{rootutils.Branch{Name: "el_pt"}, [][]float32{{1}, {2, 3}}},
+		mem_branch{rootutils.Branch{Name: "trk_hits"}, [][][]float32{{{1}}, {{2}, {3}}}},
+		mem_branch{rootutils.Branch{Name: "trk_ids"}, [][][]int32{{{1}}, {{2}, {3}}}},
+		mem_branch{rootutils.Branch{Name: "cells"}, [][][][]float64{{{{1}}}, {{{2}}}}},
+		mem_branch{rootutils.Branch{Name: "mu_pt"}, [][]float32{{1}, {2, 3}}},
+	)
+	schema := infer(t, tree, Options{})
+	check_fields(t, "Event", schema.Fields, []Field{
+		{Name: "ElPt", Type: "float", Id: 1, Branch: "el_pt", Repeated: true},
+		{Name: "TrkHits", Type: "FloatList", Id: 2, Branch: "trk_hits", Repeated: true},
+		{Name: "TrkIds", Type: "Int32List", Id: 3, Branch: "trk_ids", Repeated: true},
+		{Name: "Cells", Type: "DoubleListList", Id: 4, Branch: "cells", Repeated: true},
+		{Name: "MuPt", Type: "float", Id: 5, Branch: "mu_pt", Repeated: true},
+	})
+	check_messages(t, schema.Messages, []Message{
+		{Name: "FloatList", Fields: []Field{{Name: "v", Type: "float", Id: 1, Repeated: true}}},
+		{Name: "Int32List", Fields: []Field{{Name: "v", Type: "int32", Id: 1, Repeated: true}}},
+		{Name: "DoubleList", Fields: []Field{{Name: "v", Type: "double", Id: 1, Repeated: true}}},
+		{Name: "DoubleListList", Fields: []Field{{Name: "v", Type: "DoubleList", Id: 1, Repeated: true}}},
+	})
+	check_proto(t, schema,
+		"message FloatList {",
+		"repeated float v = 1 [packed=true];",
+		"repeated DoubleList v = 1;",
+		`repeated float ElPt = 1 [(root_branch) = "el_pt",packed=true];`,
+		`repeated FloatList TrkHits = 2 [(root_branch) = "trk_hits"];`,
+	)
+}
+
+func TestInferArrays(t *testing.T) {
+	tree := new_tree(t,
+		mem_branch{rootutils.Branch{Name: "n"}, []int32{1, 2}},
+		mem_branch{rootutils.Branch{Name: "x"}, [][3]float32{{1, 2, 3}, {4, 5, 6}}},
+		mem_branch{
+			rootutils.Branch{
+				Name:     "px",
+				TypeName: "Float_t",
+				Leaves:   []rootutils.Leaf{{Name: "px", TypeName: "Float_t", Count: "n"}},
+			},
+			[][]float32{{1}, {2, 3}},
+		},
+		mem_branch{
+			rootutils.Branch{
+				Name:     "hits",
+				TypeName: "vector<int>",
+				Leaves:   []rootutils.Leaf{{Name: "hits", TypeName: "vector<int>", Len: 2}},
+			},
+			[][2][]int32{{{1}, {2}}, {{3}, {4}}},
+		},
+	)
+	schema := infer(t, tree, Options{})
+	check_fields(t, "Event", schema.Fields, []Field{
+		{Name: "N", Type: "int32", Id: 1, Branch: "n"},
+		{Name: "X", Type: "float", Id: 2, Branch: "x", Repeated: true},
+		{Name: "Px", Type: "float", Id: 3, Branch: "px", Repeated: true},
+		{Name: "Hits", Type: "Int32List", Id: 4, Branch: "hits", Repeated: true},
+	})
+	check_messages(t, schema.Messages, []Message{
+		{Name: "Int32List", Fields: []Field{{Name: "v", Type: "int32", Id: 1, Repeated: true}}},
+	})
+	check_proto(t, schema,
+		`repeated float X = 2 [(root_branch) = "x",packed=true];`,
+		`repeated float Px = 3 [(root_branch) = "px",packed=true];`,
+	)
+}
+
+type pos struct {
+	X, Y float32
+	N    [2]int32
+}
+
+func TestInferLeaves(t *testing.T) {
+	tree := new_tree(t,
+		mem_branch{rootutils.Branch{Name: "pos"}, []pos{{1, 2, [2]int32{3, 4}}, {5, 6, [2]int32{7, 8}}}},
+		mem_branch{rootutils.Branch{Name: "n"}, []int32{1, 2}},
+	)
+	schema := infer(t, tree, Options{})
+	check_fields(t, "Event", schema.Fields, []Field{
+		{Name: "Pos", Type: "PosBranch", Id: 1, Branch: "pos"},
+		{Name: "N", Type: "int32", Id: 2, Branch: "n"},
+	})
+	check_messages(t, schema.Messages, []Message{
+		{Name: "PosBranch", Fields: []Field{
+			{Name: "X", Type: "float", Id: 1, Leaf: "X"},
+			{Name: "Y", Type: "float", Id: 2, Leaf: "Y"},
+			{Name: "N", Type: "int32", Id: 3, Leaf: "N", Repeated: true},
+		}},
+	})
+	check_proto(t, schema,
+		"message PosBranch {",
+		`optional float X = 1 [(root_leaf) = "X"];`,
+		`repeated int32 N = 3 [(root_leaf) = "N",packed=true];`,
+		`optional PosBranch Pos = 1 [(root_branch) = "pos"];`,
+	)
+}
+
+func TestInferNameClashes(t *testing.T) {
+	// branch names mapping to the same field or message name are renamed.
+	tree := new_tree(t,
+		mem_branch{rootutils.Branch{Name: "el_pt"}, []float32{1, 2}},
+		mem_branch{rootutils.Branch{Name: "ElPt"}, []float32{1, 2}},
+		mem_branch{rootutils.Branch{Name: "elPt"}, []float32{1, 2}},
+		mem_branch{rootutils.Branch{Name: "pos"}, []pos{{}, {}}},
+		mem_branch{rootutils.Branch{Name: "Pos"}, []pos{{}, {}}},
+	)
+	schema := infer(t, tree, Options{Syntax: "proto3"})
+	check_fields(t, "Event", schema.Fields, []Field{
+		{Name: "ElPt", Type: "float", Id: 1, Branch: "el_pt"},
+		{Name: "ElPt_2", Type: "float", Id: 2, Branch: "ElPt"},
+		{Name: "ElPt_3", Type: "float", Id: 3, Branch: "elPt"},
+		{Name: "Pos", Type: "PosBranch", Id: 4, Branch: "pos"},
+		{Name: "Pos_2", Type: "PosBranch_2", Id: 5, Branch: "Pos"},
+	})
+	if len(schema.Messages) != 2 || schema.Messages[1].Name != "PosBranch_2" {
+		t.Fatalf("invalid messages: %+v", schema.Messages)
+	}
+	check_proto(t, schema,
+		`float ElPt_2 = 2 [(root_branch) = "ElPt"];`,
+		"message PosBranch_2 {",
+	)
+}
+
+type track struct {
+	Px, Py float32
+	Hits   [2]int32
+}
+
+type vertex struct {
+	Z float64
+}
+
+func TestInferSplit(t *testing.T) {
+	tree := new_tree(t,
+		mem_branch{
+			rootutils.Branch{Name: "lead", TypeName: "Track", Branches: []rootutils.Branch{}},
+			[]track{{Px: 1}, {Px: 2}},
+		},
+		mem_branch{
+			rootutils.Branch{Name: "tracks", TypeName: "Track"},
+			[][]track{{{Px: 1}}, {{Px: 2}, {Px: 3}}},
+		},
+		mem_branch{
+			rootutils.Branch{Name: "vtx", TypeName: "ns::Vertex", Branches: []rootutils.Branch{}},
+			[]vertex{{1}, {2}},
+		},
+		mem_branch{
+			rootutils.Branch{Name: "pvs"},
+			[][]vertex{{{1}}, {{2}}},
+		},
+	)
+	// collections of objects of an unknown class are named after the branch.
+	tree.Branches()[3].TypeName = ""
+
+	schema := infer(t, tree, Options{})
+	check_fields(t, "Event", schema.Fields, []Field{
+		{Name: "Lead", Type: "Track", Id: 1, Branch: "lead"},
+		{Name: "Tracks", Type: "Track", Id: 2, Branch: "tracks", Repeated: true},
+		{Name: "Vtx", Type: "Ns_Vertex", Id: 3, Branch: "vtx"},
+		{Name: "Pvs", Type: "PvsObject", Id: 4, Branch: "pvs", Repeated: true},
+	})
+	check_messages(t, schema.Messages, []Message{
+		{Name: "Track", Fields: []Field{
+			{Name: "Px", Type: "float", Id: 1, Leaf: "Px"},
+			{Name: "Py", Type: "float", Id: 2, Leaf: "Py"},
+			{Name: "Hits", Type: "int32", Id: 3, Leaf: "Hits", Repeated: true},
+		}},
+		{Name: "Ns_Vertex", Fields: []Field{{Name: "Z", Type: "double", Id: 1, Leaf: "Z"}}},
+		{Name: "PvsObject", Fields: []Field{{Name: "Z", Type: "double", Id: 1, Leaf: "Z"}}},
+	})
+	check_proto(t, schema,
+		"message Track {",
+		`optional float Px = 1 [(root_leaf) = "Px"];`,
+		`repeated Track Tracks = 2 [(root_branch) = "tracks"];`,
+		`optional Ns_Vertex Vtx = 3 [(root_branch) = "vtx"];`,
+	)
+
+	nested := rootutils.NewMemTree("tree")
+	err := nested.AddBranch(rootutils.Branch{Name: "lead", TypeName: "Track", Branches: []rootutils.Branch{}},
+		[]track{{Px: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested.Branches()[0].Branches[0].Branches = []rootutils.Branch{{Name: "x"}}
+	_, err = InferSchema(nested, Options{})
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.Fatalf("nested split object: got %v, want ErrUnsupportedType", err)
+	}
+}
+
+type track_v2 struct {
+	Px, Py, Pz float32
+}
+
+func TestInferSplitConflicts(t *testing.T) {
+	// branches of the same class with different data members.
+	tree := new_tree(t,
+		mem_branch{
+			rootutils.Branch{Name: "lead", TypeName: "Track", Branches: []rootutils.Branch{}},
+			[]track{{Px: 1}, {Px: 2}},
+		},
+		mem_branch{
+			rootutils.Branch{Name: "tracks", TypeName: "Track"},
+			[][]track_v2{{{Px: 1}}, {{Px: 2}, {Px: 3}}},
+		},
+	)
+	_, err := InferSchema(tree, Options{})
+	if err == nil || !strings.Contains(err.Error(), "[tracks]") || !strings.Contains(err.Error(), "different fields") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// classes named after the generated messages.
+	for _, class := range []string{"Event", "DataHeader"} {
+		tree = new_tree(t,
+			mem_branch{
+				rootutils.Branch{Name: "evt", TypeName: class, Branches: []rootutils.Branch{}},
+				[]vertex{{1}, {2}},
+			},
+		)
+		_, err = InferSchema(tree, Options{})
+		if err == nil || !strings.Contains(err.Error(), "clashes") {
+			t.Fatalf("class %s: unexpected error: %v", class, err)
+		}
+	}
+
+	// the event message can be renamed.
+	tree = new_tree(t,
+		mem_branch{
+			rootutils.Branch{Name: "evt", TypeName: "Event", Branches: []rootutils.Branch{}},
+			[]vertex{{1}, {2}},
+		},
+	)
+	schema := infer(t, tree, Options{Message: "Entry"})
+	check_fields(t, "Entry", schema.Fields, []Field{
+		{Name: "Evt", Type: "Event", Id: 1, Branch: "evt"},
+	})
+}
+
+func TestInferUnsupported(t *testing.T) {
+	tree := new_tree(t,
+		mem_branch{rootutils.Branch{Name: "n"}, []int32{1, 2}},
+		mem_branch{rootutils.Branch{Name: "p4", TypeName: "TLorentzVector"}, []int32{1, 2}},
+		mem_branch{rootutils.Branch{Name: "ts", TypeName: "TTimeStamp"}, []int64{1, 2}},
+		mem_branch{rootutils.Branch{Name: "pt"}, []float32{1, 2}},
+	)
+
+	for _, policy := range []string{"", "error"} {
+		_, err := InferSchema(tree, Options{OnUnsupported: policy})
+		if !errors.Is(err, ErrUnsupportedType) {
+			t.Fatalf("policy %q: got %v, want ErrUnsupportedType", policy, err)
+		}
+		if !strings.Contains(err.Error(), "p4") {
+			t.Fatalf("policy %q: error does not name the branch: %v", policy, err)
+		}
+	}
+
+	schema := infer(t, tree, Options{OnUnsupported: "skip"})
+	check_fields(t, "Event", schema.Fields, []Field{
+		{Name: "N", Type: "int32", Id: 1, Branch: "n"},
+		{Name: "Pt", Type: "float", Id: 2, Branch: "pt"},
+	})
+	if len(schema.Skipped) != 2 || schema.Skipped[0].Name != "p4" || schema.Skipped[1].Name != "ts" {
+		t.Fatalf("invalid skipped branches: %+v", schema.Skipped)
+	}
+
+	schema = infer(t, tree, Options{OnUnsupported: "bytes"})
+	check_fields(t, "Event", schema.Fields, []Field{
+		{Name: "N", Type: "int32", Id: 1, Branch: "n"},
+		{Name: "P4", Type: "bytes", Id: 2, Branch: "p4"},
+		{Name: "Ts", Type: "bytes", Id: 3, Branch: "ts"},
+		{Name: "Pt", Type: "float", Id: 4, Branch: "pt"},
+	})
+	if len(schema.Skipped) != 0 {
+		t.Fatalf("invalid skipped branches: %+v", schema.Skipped)
+	}
+
+	// the type map takes precedence over the policy.
+	schema = infer(t, tree, Options{
+		OnUnsupported: "skip",
+		TypeMap:       TypeMap{"TTimeStamp": {Type: "int64"}},
+	})
+	check_fields(t, "Event", schema.Fields, []Field{
+		{Name: "N", Type: "int32", Id: 1, Branch: "n"},
+		{Name: "Ts", Type: "int64", Id: 2, Branch: "ts"},
+		{Name: "Pt", Type: "float", Id: 3, Branch: "pt"},
+	})
+
+	_, err := InferSchema(tree, Options{OnUnsupported: "ignore"})
+	if err == nil {
+		t.Fatalf("expected an error for an invalid policy")
+	}
+}
+
+func TestInferTypeMapFile(t *testing.T) {
+	// typedefs and classes of ROOT files can be mapped with the type map.
+	typemap := TypeMap{
+		"Float16_t":      {Type: "int32"},
+		"TLorentzVector": {Type: "bytes"},
+	}
+	for _, tc := range []struct {
+		fname string
+		sel   string
+		want  []Field
+	}{
+		{
+			fname: "leaves.root",
+			sel:   "D16,D32,F32",
+			want: []Field{
+				{Name: "F32", Type: "float", Id: 1, Branch: "F32"},
+				{Name: "D16", Type: "int32", Id: 2, Branch: "D16"},
+				{Name: "D32", Type: "double", Id: 3, Branch: "D32"},
+			},
+		},
+		{
+			fname: "tlv-split00.root",
+			want:  []Field{{Name: "P4", Type: "bytes", Id: 1, Branch: "p4"}},
+		},
+	} {
+		t.Run(tc.fname, func(t *testing.T) {
+			tree, err := rootutils.Open("../rootutils/testdata/"+tc.fname, "tree")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+			schema := infer(t, tree, Options{Selection: tc.sel, TypeMap: typemap})
+			check_fields(t, "Event", schema.Fields, tc.want)
+		})
+	}
+}
+
+// EOF
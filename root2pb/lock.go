@@ -0,0 +1,118 @@
+package root2pb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// FieldLock records the field numbers of the branches of a tree, so they
+// stay the same when the schema is regenerated after branches have been
+// added, removed or reordered.
+type FieldLock struct {
+	Fields  map[string]int `json:"fields"`            // field numbers, by branch name
+	Removed map[string]int `json:"removed,omitempty"` // field numbers of the removed branches
+}
+
+// NewFieldLock returns a new empty lock.
+func NewFieldLock() *FieldLock {
+	return &FieldLock{
+		Fields:  make(map[string]int),
+		Removed: make(map[string]int),
+	}
+}
+
+// LoadFieldLock reads a lock from the JSON file fname.
+// An empty lock is returned if the file does not exist.
+func LoadFieldLock(fname string) (*FieldLock, error) {
+	lock := NewFieldLock()
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lock, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(data, lock)
+	if err != nil {
+		return nil, fmt.Errorf("root2pb: invalid lock file [%s]: %v", fname, err)
+	}
+	if lock.Fields == nil {
+		lock.Fields = make(map[string]int)
+	}
+	if lock.Removed == nil {
+		lock.Removed = make(map[string]int)
+	}
+	return lock, nil
+}
+
+// Save writes the lock to the JSON file fname.
+func (lock *FieldLock) Save(fname string) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, append(data, '\n'), 0644)
+}
+
+// apply numbers the fields of the schema according to the lock: locked
+// branches keep their number, new ones get fresh numbers, and the numbers
+// and names of the removed ones are reserved.
+// The lock is updated accordingly.
+func (lock *FieldLock) apply(s *Schema) {
+	next := 1
+	for _, id := range lock.Fields {
+		if id >= next {
+			next = id + 1
+		}
+	}
+	for _, id := range lock.Removed {
+		if id >= next {
+			next = id + 1
+		}
+	}
+
+	branches := make(map[string]bool, len(s.Fields))
+	names := make(map[string]bool, len(s.Fields))
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		branches[f.Branch] = true
+		names[f.Name] = true
+		if id, ok := lock.Fields[f.Branch]; ok {
+			f.Id = id
+			continue
+		}
+		if id, ok := lock.Removed[f.Branch]; ok {
+			// branch coming back, with its old number.
+			f.Id = id
+			delete(lock.Removed, f.Branch)
+			lock.Fields[f.Branch] = id
+			continue
+		}
+		f.Id = next
+		next++
+		lock.Fields[f.Branch] = f.Id
+	}
+
+	for branch, id := range lock.Fields {
+		if !branches[branch] {
+			delete(lock.Fields, branch)
+			lock.Removed[branch] = id
+		}
+	}
+
+	s.Reserved = s.Reserved[:0]
+	s.ReservedNames = s.ReservedNames[:0]
+	for branch, id := range lock.Removed {
+		s.Reserved = append(s.Reserved, id)
+		if name := camel_case(branch); !names[name] {
+			s.ReservedNames = append(s.ReservedNames, name)
+		}
+	}
+	sort.Ints(s.Reserved)
+	sort.Strings(s.ReservedNames)
+}
+
+// EOF
@@ -0,0 +1,122 @@
+package root2pb
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// lock_schema returns a schema with one int32 field per branch, numbered
+// by lock.
+func lock_schema(lock *FieldLock, branches ...string) *Schema {
+	s := &Schema{}
+	for i, br := range branches {
+		s.Fields = append(s.Fields, Field{Name: camel_case(br), Type: "int32", Id: i + 1, Branch: br})
+	}
+	lock.apply(s)
+	return s
+}
+
+func field_ids(s *Schema) map[string]int {
+	ids := make(map[string]int, len(s.Fields))
+	for _, f := range s.Fields {
+		ids[f.Branch] = f.Id
+	}
+	return ids
+}
+
+func TestFieldLock(t *testing.T) {
+	lock := NewFieldLock()
+
+	s := lock_schema(lock, "a", "b", "c")
+	if got, want := field_ids(s), map[string]int{"a": 1, "b": 2, "c": 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("initial numbers: got %v, want %v", got, want)
+	}
+	if len(s.Reserved) != 0 || len(s.ReservedNames) != 0 {
+		t.Fatalf("unexpected reserved fields: %v %v", s.Reserved, s.ReservedNames)
+	}
+
+	// reordered branches keep their number, new ones get fresh numbers.
+	s = lock_schema(lock, "d", "c", "a", "b")
+	if got, want := field_ids(s), map[string]int{"a": 1, "b": 2, "c": 3, "d": 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("reordered numbers: got %v, want %v", got, want)
+	}
+
+	// removed branches are reserved, and their numbers are not reused.
+	s = lock_schema(lock, "a", "d", "e")
+	if got, want := field_ids(s), map[string]int{"a": 1, "d": 4, "e": 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("numbers after removal: got %v, want %v", got, want)
+	}
+	if want := []int{2, 3}; !reflect.DeepEqual(s.Reserved, want) {
+		t.Fatalf("reserved numbers: got %v, want %v", s.Reserved, want)
+	}
+	if want := []string{"B", "C"}; !reflect.DeepEqual(s.ReservedNames, want) {
+		t.Fatalf("reserved names: got %v, want %v", s.ReservedNames, want)
+	}
+	if want := map[string]int{"b": 2, "c": 3}; !reflect.DeepEqual(lock.Removed, want) {
+		t.Fatalf("removed branches: got %v, want %v", lock.Removed, want)
+	}
+
+	// branches coming back get their old number back.
+	s = lock_schema(lock, "a", "b", "d", "e", "f")
+	if got, want := field_ids(s), map[string]int{"a": 1, "b": 2, "d": 4, "e": 5, "f": 6}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("numbers after re-adding: got %v, want %v", got, want)
+	}
+	if want := []int{3}; !reflect.DeepEqual(s.Reserved, want) {
+		t.Fatalf("reserved numbers: got %v, want %v", s.Reserved, want)
+	}
+	if want := map[string]int{"c": 3}; !reflect.DeepEqual(lock.Removed, want) {
+		t.Fatalf("removed branches: got %v, want %v", lock.Removed, want)
+	}
+}
+
+func TestFieldLockReservedNames(t *testing.T) {
+	// the name of a removed branch is not reserved when a new branch maps
+	// to the same field name.
+	lock := NewFieldLock()
+	lock_schema(lock, "el_pt", "mu")
+	s := lock_schema(lock, "ElPt", "mu")
+	if want := []int{1}; !reflect.DeepEqual(s.Reserved, want) {
+		t.Fatalf("reserved numbers: got %v, want %v", s.Reserved, want)
+	}
+	if len(s.ReservedNames) != 0 {
+		t.Fatalf("reserved names: got %v, want none", s.ReservedNames)
+	}
+	if got, want := field_ids(s), map[string]int{"ElPt": 3, "mu": 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("numbers: got %v, want %v", got, want)
+	}
+}
+
+func TestFieldLockFile(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "event.lock")
+
+	lock, err := LoadFieldLock(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lock.Fields) != 0 || len(lock.Removed) != 0 {
+		t.Fatalf("expected an empty lock, got %+v", lock)
+	}
+
+	lock_schema(lock, "a", "b")
+	lock_schema(lock, "b", "c")
+	err = lock.Save(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadFieldLock(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, lock) {
+		t.Fatalf("lock round trip:\ngot:  %+v\nwant: %+v", got, lock)
+	}
+
+	s := lock_schema(got, "c", "a", "d")
+	if got, want := field_ids(s), map[string]int{"a": 1, "c": 3, "d": 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("numbers from the lock file: got %v, want %v", got, want)
+	}
+}
+
+// EOF
@@ -0,0 +1,174 @@
+package root2pb
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/sbinet/go-root2pb/rootutils"
+	"github.com/sbinet/go-root2pb/templates"
+)
+
+// Schema describes the protobuf package generated out of a tree.
+type Schema struct {
+	Package string  // name of the protobuf package
+	Message string  // name of the message encoding a tree entry
+	Fields  []Field // fields of the message, one per selected branch
+	Syntax  string  // syntax of the .proto files: "proto2" (default) or "proto3"
+
+	// Messages are the additional messages used by the fields of the
+	// message encoding a tree entry (e.g. wrappers of nested vectors.)
+	Messages []Message
+
+	// Skipped are the selected branches left out of the schema because
+	// their type has no protobuf equivalent (see Options.OnUnsupported.)
+	Skipped []rootutils.Branch
+
+	// Imports are the additional .proto files defining the types of the
+	// fields (e.g. well-known types used by a user type map.)
+	Imports []string
+
+	// Reserved and ReservedNames are the field numbers and names of the
+	// branches removed since the field numbers were locked (see FieldLock.)
+	Reserved      []int
+	ReservedNames []string
+
+	typemap TypeMap
+}
+
+// Message describes an additional message of a schema.
+type Message struct {
+	Name   string
+	Fields []Field
+}
+
+// GenerateProto writes the .proto file describing schema to w.
+// With the proto3 syntax, the file imports the one declaring the custom
+// options, written by GenerateOptions.
+func GenerateProto(schema *Schema, w io.Writer) error {
+	return GenerateProtoFS(schema, templates.FS, w)
+}
+
+// GenerateProtoFS is like GenerateProto, with the templates read from fsys
+// (proto2.proto.tmpl or proto3.proto.tmpl, see the templates package.)
+func GenerateProtoFS(schema *Schema, fsys fs.FS, w io.Writer) error {
+	name := "proto2.proto.tmpl"
+	if schema.Syntax == "proto3" {
+		name = "proto3.proto.tmpl"
+	}
+	t, err := template.New(name).Funcs(ProtoFuncs).ParseFS(fsys, name)
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, schema.with_syntax())
+}
+
+// GenerateProtoTemplate writes the .proto file describing schema to w, out
+// of the user template fname, instead of the default one.
+//
+// The template is executed against the schema, with its fields rendered
+// according to the syntax of the schema:
+//   - .Package, .Message, .Syntax: names of the package, of the message
+//     encoding a tree entry and syntax ("proto2" or "proto3"),
+//   - .Fields: fields of the message encoding a tree entry, with .Name,
+//     .Type, .Id, .Branch, .Leaf, .Repeated and the .Modifier (label) and
+//     .Attr (options, e.g. (root_branch)) methods,
+//   - .Messages: additional messages (.Name, .Fields),
+//   - .Imports: additional .proto files to import,
+//   - .Reserved, .ReservedNames: field numbers and names to reserve,
+//   - .OptionsFile: .proto file declaring the custom options (proto3.)
+//
+// The default templates of fsys (see GenerateProtoFS) can be included with
+// {{template "proto2.proto.tmpl" .}} or {{template "proto3.proto.tmpl" .}}
+// (e.g. to only add a license header, file options or extra messages.)
+// The functions of ProtoFuncs are available.
+func GenerateProtoTemplate(schema *Schema, fsys fs.FS, fname string, w io.Writer) error {
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	t, err := template.New("proto-template").Funcs(ProtoFuncs).ParseFS(
+		fsys, "proto2.proto.tmpl", "proto3.proto.tmpl",
+	)
+	if err != nil {
+		return err
+	}
+	t, err = t.Parse(string(src))
+	if err != nil {
+		return fmt.Errorf("root2pb: invalid template [%s]: %v", fname, err)
+	}
+	return t.Execute(w, schema.with_syntax())
+}
+
+// ProtoFuncs are the functions available to the templates of the .proto
+// files:
+//   - quote: a string as a protobuf string literal,
+//   - camel: a name CamelCased, as the names of the generated fields,
+//   - join: strings.Join,
+//   - lower, upper: strings.ToLower, strings.ToUpper,
+//   - replace: strings.Replace of all the occurrences (replace s old new),
+//   - env: os.Getenv.
+var ProtoFuncs = template.FuncMap{
+	"quote":   func(s string) string { return fmt.Sprintf("%q", s) },
+	"camel":   camel_case,
+	"join":    strings.Join,
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": func(s, old, new string) string { return strings.Replace(s, old, new, -1) },
+	"env":     os.Getenv,
+}
+
+// GenerateOptions writes the .proto file declaring the custom options used
+// by the proto3 .proto file of schema, named after OptionsFile, to w.
+func GenerateOptions(schema *Schema, w io.Writer) error {
+	return GenerateOptionsFS(schema, templates.FS, w)
+}
+
+// GenerateOptionsFS is like GenerateOptions, with the template read from
+// fsys (options.proto.tmpl, see the templates package.)
+func GenerateOptionsFS(schema *Schema, fsys fs.FS, w io.Writer) error {
+	t, err := template.New("options.proto.tmpl").Funcs(ProtoFuncs).ParseFS(fsys, "options.proto.tmpl")
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, schema)
+}
+
+// OptionsFile returns the name of the .proto file declaring the custom
+// options (root_branch, root_leaf), or "" if they are declared in the
+// .proto file of the schema (proto2.)
+func (s *Schema) OptionsFile() string {
+	if s.Syntax != "proto3" {
+		return ""
+	}
+	return strings.Replace(s.Package, ".", "_", -1) + "_options.proto"
+}
+
+// with_syntax returns a copy of the schema whose fields are rendered
+// according to its syntax.
+func (s *Schema) with_syntax() *Schema {
+	if s.Syntax != "proto3" {
+		return s
+	}
+	proto3 := func(fields []Field) []Field {
+		o := make([]Field, len(fields))
+		for i, f := range fields {
+			f.proto3 = true
+			o[i] = f
+		}
+		return o
+	}
+	o := *s
+	o.Fields = proto3(s.Fields)
+	o.Messages = make([]Message, len(s.Messages))
+	for i, msg := range s.Messages {
+		o.Messages[i] = Message{Name: msg.Name, Fields: proto3(msg.Fields)}
+	}
+	return &o
+}
+
+// EOF
@@ -0,0 +1,244 @@
+// Package "root2pb" generates protobuf schemas out of the branches of ROOT
+// flat n-tuples and converts their entries into protobuf messages.
+package root2pb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sbinet/go-root2pb/rootutils"
+)
+
+var (
+	// ErrFileNotFound is returned when a ROOT file could not be opened.
+	ErrFileNotFound = rootutils.ErrFileNotFound
+
+	// ErrTreeNotFound is returned when a ROOT file holds no tree with the
+	// requested name.
+	ErrTreeNotFound = rootutils.ErrTreeNotFound
+
+	// ErrUnsupportedType is returned when the type of a branch has no
+	// protobuf equivalent.
+	ErrUnsupportedType = errors.New("root2pb: unsupported type")
+)
+
+// rt2pb_typemap maps ROOT types (basic types, C++ types and leaf type codes)
+// to protobuf types.
+// 8 and 16-bit integers, which have no protobuf equivalent, are widened to
+// 32-bit ones. Float16_t and Double32_t are read in memory as float and
+// double.
+var rt2pb_typemap = map[string]string{
+	"Char_t":     "int32",
+	"UChar_t":    "uint32",
+	"Short_t":    "int32",
+	"UShort_t":   "uint32",
+	"Int_t":      "int32",
+	"UInt_t":     "uint32",
+	"Int32_t":    "int32",
+	"UInt32_t":   "uint32",
+	"Long_t":     "int64",
+	"ULong_t":    "uint64",
+	"Long64_t":   "int64",
+	"ULong64_t":  "uint64",
+	"Float_t":    "float",
+	"Float16_t":  "float",
+	"Double_t":   "double",
+	"Double32_t": "double",
+	"Bool_t":     "bool",
+
+	"std::string": "string",
+	"string":      "string",
+	"TString":     "string",
+
+	"char":               "int32",
+	"signed char":        "int32",
+	"unsigned char":      "uint32",
+	"short":              "int32",
+	"unsigned short":     "uint32",
+	"int":                "int32",
+	"unsigned int":       "uint32",
+	"long":               "int64",
+	"unsigned long":      "uint64",
+	"long long":          "int64",
+	"unsigned long long": "uint64",
+	"float":              "float",
+	"double":             "double",
+	"bool":               "bool",
+
+	// leaf type codes (x/F)
+	"B": "int32",
+	"b": "uint32",
+	"S": "int32",
+	"s": "uint32",
+	"I": "int32",
+	"i": "uint32",
+	"L": "int64",
+	"l": "uint64",
+	"F": "float",
+	"f": "float",
+	"D": "double",
+	"d": "double",
+	"O": "bool",
+	"C": "string",
+}
+
+// pb_scalars is the set of protobuf scalar types.
+var pb_scalars = map[string]bool{
+	"double":   true,
+	"float":    true,
+	"int32":    true,
+	"int64":    true,
+	"uint32":   true,
+	"uint64":   true,
+	"sint32":   true,
+	"sint64":   true,
+	"fixed32":  true,
+	"fixed64":  true,
+	"sfixed32": true,
+	"sfixed64": true,
+	"bool":     true,
+	"string":   true,
+	"bytes":    true,
+}
+
+// pb_type returns the protobuf type corresponding to the ROOT type typename,
+// and whether it is a repeated one.
+// The user type map takes precedence over rt2pb_typemap.
+// Nested vectors are mapped to repeated wrapper messages, which are added to
+// the schema.
+func (s *Schema) pb_type(typename string) (pb_type string, isrepeated bool, err error) {
+	v, ok := s.user_type(typename)
+	if ok {
+		return v, false, nil
+	}
+	v, ok = rt2pb_typemap[typename]
+	if ok {
+		return v, false, nil
+	}
+	v = typename
+	for _, prefix := range []string{"vector<", "std::vector<"} {
+		if strings.HasPrefix(v, prefix) {
+			v = strings.TrimSpace(v[len(prefix) : len(v)-1])
+			isrepeated = true
+			break
+		}
+	}
+	if isrepeated {
+		var inner bool
+		v, inner, err = s.pb_type(v)
+		if err != nil {
+			return "", false, err
+		}
+		if inner {
+			v = s.add_list(v)
+		}
+		return v, isrepeated, nil
+	}
+	if pb_scalars[v] {
+		return v, false, nil
+	}
+	return "", false, fmt.Errorf("%w: [%s]", ErrUnsupportedType, typename)
+}
+
+// add_list adds to the schema a wrapper message holding a repeated field of
+// type pb_type, and returns its name.
+func (s *Schema) add_list(pb_type string) string {
+	name := camel_case(pb_type[strings.LastIndex(pb_type, ".")+1:]) + "List"
+	for _, msg := range s.Messages {
+		if msg.Name == name {
+			return name
+		}
+	}
+	s.Messages = append(s.Messages, Message{
+		Name: name,
+		Fields: []Field{
+			{Name: "v", Type: pb_type, Id: 1, Repeated: true},
+		},
+	})
+	return name
+}
+
+// Field encodes the informations about a tree's branch or leaf.
+type Field struct {
+	Name     string // name of the field
+	Type     string // protobuf type of the field
+	Id       int    // field number
+	Branch   string // branch the field is generated from, if any
+	Leaf     string // leaf (or data member) the field is generated from, if any
+	Repeated bool   // whether the field is repeated
+
+	proto3 bool // rendered with the proto3 syntax
+	//tag     string
+}
+
+// Attr returns the options of the field.
+// Repeated scalar fields are packed (by default with the proto3 syntax.)
+func (f Field) Attr() string {
+	attrs := []string{}
+	if f.Branch != "" {
+		attrs = append(attrs, fmt.Sprintf(`(root_branch) = %q`, f.Branch))
+	}
+	if f.Leaf != "" {
+		attrs = append(attrs, fmt.Sprintf(`(root_leaf) = %q`, f.Leaf))
+	}
+	if f.Repeated && !f.proto3 && pb_scalars[f.Type] && f.Type != "string" && f.Type != "bytes" {
+		attrs = append(attrs, "packed=true")
+	}
+	if len(attrs) > 0 {
+		return fmt.Sprintf(" [%s]", strings.Join(attrs, ","))
+	}
+	return ""
+}
+
+// Modifier returns the label of the field.
+func (f Field) Modifier() string {
+	if f.Repeated {
+		return "repeated"
+	}
+	if f.proto3 {
+		// implicit presence
+		return ""
+	}
+	//return "required"
+	return "optional"
+}
+
+// camel_case returns the CamelCased name of a branch, leaf or type, as
+// protoc-gen-go names Go identifiers: underscores followed by a lowercase
+// letter are dropped and that letter is upper-cased, as is the first one.
+// A leading underscore is replaced by 'X'.
+func camel_case(s string) string {
+	if s == "" {
+		return ""
+	}
+	is_lower := func(c byte) bool { return 'a' <= c && c <= 'z' }
+	is_digit := func(c byte) bool { return '0' <= c && c <= '9' }
+	t := make([]byte, 0, len(s)+1)
+	i := 0
+	if s[0] == '_' {
+		t = append(t, 'X')
+		i++
+	}
+	for ; i < len(s); i++ {
+		c := s[i]
+		if c == '_' && i+1 < len(s) && is_lower(s[i+1]) {
+			continue
+		}
+		if is_digit(c) {
+			t = append(t, c)
+			continue
+		}
+		if is_lower(c) {
+			c ^= ' '
+		}
+		t = append(t, c)
+		for i+1 < len(s) && is_lower(s[i+1]) {
+			i++
+			t = append(t, s[i])
+		}
+	}
+	return string(t)
+}
+
+// EOF
@@ -0,0 +1,111 @@
+package root2pb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// TypeMap maps ROOT type names to protobuf types, extending or overriding
+// the default mapping (e.g. for typedefs or custom classes.)
+type TypeMap map[string]PbType
+
+// PbType describes a protobuf type: a scalar type, a well-known type
+// (e.g. "google.protobuf.Timestamp") or a custom message.
+type PbType struct {
+	Type   string `json:"type"`             // name of the protobuf type
+	Import string `json:"import,omitempty"` // .proto file defining a message type
+}
+
+// UnmarshalJSON decodes a PbType from either its name ("float") or an
+// object ({"type": "geo.Vec3", "import": "geo/vec3.proto"}.)
+func (t *PbType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = PbType{Type: name}
+		return nil
+	}
+	type pbtype PbType
+	return json.Unmarshal(data, (*pbtype)(t))
+}
+
+// LoadTypeMap reads a TypeMap from the JSON file fname:
+//
+//	{
+//	  "MyFloat_t": "float",
+//	  "Weight_t":  "google.protobuf.FloatValue",
+//	  "Vec3":      {"type": "geo.Vec3", "import": "geo/vec3.proto"}
+//	}
+//
+// Only scalar types and messages with a single field (e.g. the wrappers of
+// the well-known types) can be filled by ReadTree.
+func LoadTypeMap(fname string) (TypeMap, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	tmap := make(TypeMap)
+	err = json.Unmarshal(data, &tmap)
+	if err != nil {
+		return nil, fmt.Errorf("root2pb: invalid type map [%s]: %v", fname, err)
+	}
+	for rt, pt := range tmap {
+		if pt.Type == "" {
+			return nil, fmt.Errorf("root2pb: invalid type map [%s]: no protobuf type for [%s]",
+				fname, rt)
+		}
+	}
+	return tmap, nil
+}
+
+// pb_wkt_files maps the protobuf well-known types to the .proto files
+// defining them.
+var pb_wkt_files = map[string]string{
+	"google.protobuf.Any":         "google/protobuf/any.proto",
+	"google.protobuf.Duration":    "google/protobuf/duration.proto",
+	"google.protobuf.Empty":       "google/protobuf/empty.proto",
+	"google.protobuf.FieldMask":   "google/protobuf/field_mask.proto",
+	"google.protobuf.Struct":      "google/protobuf/struct.proto",
+	"google.protobuf.Value":       "google/protobuf/struct.proto",
+	"google.protobuf.ListValue":   "google/protobuf/struct.proto",
+	"google.protobuf.Timestamp":   "google/protobuf/timestamp.proto",
+	"google.protobuf.DoubleValue": "google/protobuf/wrappers.proto",
+	"google.protobuf.FloatValue":  "google/protobuf/wrappers.proto",
+	"google.protobuf.Int64Value":  "google/protobuf/wrappers.proto",
+	"google.protobuf.UInt64Value": "google/protobuf/wrappers.proto",
+	"google.protobuf.Int32Value":  "google/protobuf/wrappers.proto",
+	"google.protobuf.UInt32Value": "google/protobuf/wrappers.proto",
+	"google.protobuf.BoolValue":   "google/protobuf/wrappers.proto",
+	"google.protobuf.StringValue": "google/protobuf/wrappers.proto",
+	"google.protobuf.BytesValue":  "google/protobuf/wrappers.proto",
+}
+
+// user_type returns the protobuf type the user type map associates with
+// the ROOT type typename, adding to the schema the import it needs.
+func (s *Schema) user_type(typename string) (string, bool) {
+	pt, ok := s.typemap[typename]
+	if !ok {
+		return "", false
+	}
+	imp := pt.Import
+	if imp == "" && strings.HasPrefix(pt.Type, "google.protobuf.") {
+		imp = pb_wkt_files[pt.Type]
+	}
+	if imp != "" {
+		s.add_import(imp)
+	}
+	return pt.Type, true
+}
+
+// add_import adds the .proto file fname to the imports of the schema.
+func (s *Schema) add_import(fname string) {
+	for _, imp := range s.Imports {
+		if imp == fname {
+			return
+		}
+	}
+	s.Imports = append(s.Imports, fname)
+}
+
+// EOF
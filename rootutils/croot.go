@@ -0,0 +1,368 @@
+//go:build croot
+// +build croot
+
+package rootutils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-hep/croot"
+	"github.com/gonuts/ffi"
+)
+
+// ctree is a TreeSource reading ROOT files with croot.
+type ctree struct {
+	f croot.File
+	t croot.Tree
+}
+
+func open(fname, tname string) (TreeSource, error) {
+	f := croot.OpenFile(fname, "read", "ROOT file", 1, 0)
+	if f == nil {
+		return nil, fmt.Errorf("%w: could not open ROOT file [%s]", ErrFileNotFound, fname)
+	}
+
+	t := f.GetTree(tname)
+	if t == nil {
+		f.Close("")
+		return nil, fmt.Errorf("%w: could not retrieve Tree [%s] from file [%s]",
+			ErrTreeNotFound, tname, fname)
+	}
+
+	return &ctree{f: f, t: t}, nil
+}
+
+func (t *ctree) Name() string {
+	return t.t.GetName()
+}
+
+func (t *ctree) Entries() int64 {
+	return int64(t.t.GetEntries())
+}
+
+func (t *ctree) Branches() []Branch {
+	objs := t.t.GetListOfBranches()
+	imax := objs.GetSize()
+	branches := make([]Branch, 0, imax)
+	for i := int64(0); i < imax; i++ {
+		br := objs.At(i).(croot.Branch)
+		branches = append(branches, t.branch(br, br.GetName()))
+	}
+	return branches
+}
+
+// branch describes the croot branch br, under the given name.
+func (t *ctree) branch(br croot.Branch, name string) Branch {
+	lobjs := br.GetListOfLeaves()
+	leaves := make([]Leaf, 0, lobjs.GetSize())
+	for j := int64(0); j < lobjs.GetSize(); j++ {
+		leaf := lobjs.At(j).(croot.Leaf)
+		if leaf.ClassName() == "TLeafC" {
+			// C strings are read as scalars: ROOT reports them as Char_t,
+			// with the maximum length of the strings as length.
+			leaves = append(leaves, Leaf{Name: leaf.GetName(), TypeName: "C"})
+			continue
+		}
+		count := ""
+		if lc := leaf.GetLeafCount(); lc != nil {
+			count = lc.GetName()
+		}
+		leaves = append(leaves, Leaf{
+			Name:     leaf.GetName(),
+			TypeName: leaf.GetTypeName(),
+			Len:      leaf.GetLenStatic(),
+			Count:    count,
+		})
+	}
+	typename := br.GetClassName()
+	if typename == "" && len(leaves) > 0 {
+		typename = leaves[0].TypeName
+	}
+	descr := Branch{
+		Name:     name,
+		TypeName: typename,
+		Entries:  int64(br.GetEntries()),
+		Leaves:   leaves,
+	}
+
+	// split object: one sub-branch per data member.
+	subs := br.GetListOfBranches()
+	for j := int64(0); j < subs.GetSize(); j++ {
+		sub := subs.At(j).(croot.Branch)
+		descr.Branches = append(descr.Branches,
+			t.branch(sub, member_name(br.GetName(), sub.GetName())))
+	}
+	if subs.GetSize() > 0 {
+		// the class of the split objects (of the objects of a TClonesArray
+		// for collections) is the one holding the data members of the
+		// sub-branches.
+		descr.TypeName = subs.At(0).(croot.Branch).GetClassName()
+	}
+	return split_branch(descr)
+}
+
+func (t *ctree) Read(vars []ReadVar, f func(ientry int64, values []reflect.Value) error) error {
+	descrs := make(map[string]Branch)
+	for _, br := range t.Branches() {
+		descrs[br.Name] = br
+	}
+
+	// each branch is read once, into cvals, even when several of its leaves
+	// are requested.
+	ibrs := make(map[string]int)
+	cvals := make([]ffi.Value, 0, len(vars))
+	counts := make([]croot.Leaf, 0, len(vars))
+	lens := make([]int, 0, len(vars))
+	cstrs := make([]bool, 0, len(vars)) // whether the value is a C string
+
+	ivals := make([]int, len(vars))   // index into cvals of each variable
+	ileaves := make([]int, len(vars)) // index of the leaf, for multi-leaf branches
+	for i, v := range vars {
+		br, ok := descrs[v.Branch]
+		if !ok {
+			return fmt.Errorf("rootutils: no branch [%s] in Tree [%s]", v.Branch, t.Name())
+		}
+		if len(br.Branches) > 0 {
+			// data member of a split object, read from its own sub-branch.
+			ok = false
+			for _, sub := range br.Branches {
+				if sub.Name == v.Leaf {
+					br, ok = sub, true
+					br.Name = v.Branch + "." + sub.Name
+				}
+			}
+			if !ok {
+				return fmt.Errorf("rootutils: no data member [%s] in branch [%s]", v.Leaf, v.Branch)
+			}
+			if t.t.GetBranch(br.Name) == nil {
+				// top-level split objects do not prefix their sub-branches.
+				br.Name = v.Leaf
+			}
+			v = ReadVar{Branch: br.Name}
+		}
+		ileaves[i] = -1
+		if len(br.Leaves) > 1 {
+			for j, leaf := range br.Leaves {
+				if leaf.Name == v.Leaf {
+					ileaves[i] = j
+				}
+			}
+			if ileaves[i] < 0 {
+				return fmt.Errorf("rootutils: no leaf [%s] in branch [%s]", v.Leaf, v.Branch)
+			}
+		}
+		if j, ok := ibrs[v.Branch]; ok {
+			ivals[i] = j
+			continue
+		}
+
+		var count croot.Leaf
+		ct, err := t.ffi_branch_type(br, &count)
+		if err != nil {
+			return fmt.Errorf("rootutils: branch [%s]: %v", v.Branch, err)
+		}
+
+		var cval ffi.Value
+		if ct.Kind() == ffi.Slice {
+			cval = ffi.MakeSlice(ct, 0, 10)
+		} else {
+			cval = ffi.New(ct)
+		}
+		rc := t.t.SetBranchAddress(v.Branch, cval)
+		if rc < 0 {
+			return fmt.Errorf("rootutils: problem setting branch address for [%s]: %v", v.Branch, rc)
+		}
+		ibrs[v.Branch] = len(cvals)
+		ivals[i] = len(cvals)
+		cvals = append(cvals, cval)
+		counts = append(counts, count)
+		cstrs = append(cstrs, br.TypeName == "C")
+		if count != nil {
+			lens = append(lens, br.Leaves[0].Len)
+		} else {
+			lens = append(lens, 0)
+		}
+	}
+
+	gvals := make([]reflect.Value, len(cvals))
+	values := make([]reflect.Value, len(vars))
+	nentries := t.Entries()
+	for ientry := int64(0); ientry < nentries; ientry++ {
+		rc := t.t.GetEntry(ientry, 1)
+		if rc <= 0 {
+			return fmt.Errorf("rootutils: problem loading entry [%v]: %v", ientry, rc)
+		}
+		for j, v := range cvals {
+			gvals[j] = v.GoValue()
+			if cstrs[j] {
+				gvals[j] = reflect.ValueOf(c_string(gvals[j]))
+				continue
+			}
+			if counts[j] != nil {
+				// only keep the elements counted for this entry.
+				n := int(counts[j].GetValue(0))
+				if lens[j] > 1 {
+					n *= lens[j]
+				}
+				v := reflect.MakeSlice(reflect.SliceOf(gvals[j].Type().Elem()), n, n)
+				reflect.Copy(v, gvals[j])
+				gvals[j] = v
+			}
+		}
+		for i, j := range ivals {
+			values[i] = gvals[j]
+			if ileaves[i] >= 0 {
+				values[i] = values[i].Field(ileaves[i])
+			}
+		}
+		err := f(ientry, values)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ffi_branch_type returns the C type a branch is read into.
+// count is set to the counter leaf of variable length arrays.
+func (t *ctree) ffi_branch_type(br Branch, count *croot.Leaf) (ffi.Type, error) {
+	if len(br.Leaves) > 1 {
+		// multi-leaf branches are read into a struct with one field per leaf.
+		fields := make([]ffi.Field, len(br.Leaves))
+		for j, leaf := range br.Leaves {
+			if leaf.Count != "" {
+				return nil, fmt.Errorf("variable length leaf [%s] not supported by croot", leaf.Name)
+			}
+			ct, err := ffi_type(leaf.TypeName)
+			if err != nil {
+				return nil, err
+			}
+			if leaf.IsArray() {
+				ct, err = ffi.NewArrayType(leaf.Len, ct)
+				if err != nil {
+					return nil, err
+				}
+			}
+			fields[j] = ffi.Field{Name: leaf.Name, Type: ct}
+		}
+		return ffi.NewStructType(br.Name, fields)
+	}
+
+	if br.TypeName == "C" {
+		// C strings are read into an array large enough to hold their
+		// maximum length, and their terminating null character.
+		n := t.t.GetLeaf(br.Leaves[0].Name).GetLenStatic()
+		return ffi.NewArrayType(n+1, ffi.C_int8)
+	}
+	ct, err := ffi_type(br.TypeName)
+	if err != nil {
+		return nil, err
+	}
+	if len(br.Leaves) == 1 && br.Leaves[0].IsArray() {
+		// C-style arrays are read into an array large enough to hold
+		// their maximum number of elements.
+		leaf := br.Leaves[0]
+		n := leaf.Len
+		if n < 1 {
+			n = 1
+		}
+		if leaf.Count != "" {
+			*count = t.t.GetLeaf(leaf.Count)
+			n *= (*count).GetMaximum()
+		}
+		return ffi.NewArrayType(n, ct)
+	}
+	return ct, nil
+}
+
+// c_string returns the null-terminated string held by the array of chars v.
+func c_string(v reflect.Value) string {
+	buf := make([]byte, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		c := byte(v.Index(i).Int())
+		if c == 0 {
+			break
+		}
+		buf = append(buf, c)
+	}
+	return string(buf)
+}
+
+func (t *ctree) Close() error {
+	t.f.Close("")
+	return nil
+}
+
+// ffi_types maps ROOT types (basic types, C++ types and leaf type codes)
+// to the C types croot reads them into.
+var ffi_types = map[string]ffi.Type{
+	"Char_t":     ffi.C_int8,
+	"UChar_t":    ffi.C_uint8,
+	"Short_t":    ffi.C_int16,
+	"UShort_t":   ffi.C_uint16,
+	"Int_t":      ffi.C_int32,
+	"UInt_t":     ffi.C_uint32,
+	"Int32_t":    ffi.C_int32,
+	"UInt32_t":   ffi.C_uint32,
+	"Long_t":     ffi.C_int64,
+	"ULong_t":    ffi.C_uint64,
+	"Long64_t":   ffi.C_int64,
+	"ULong64_t":  ffi.C_uint64,
+	"Float_t":    ffi.C_float,
+	"Float16_t":  ffi.C_float,
+	"Double_t":   ffi.C_double,
+	"Double32_t": ffi.C_double,
+	"Bool_t":     ffi.C_int8,
+
+	"char":               ffi.C_int8,
+	"signed char":        ffi.C_int8,
+	"unsigned char":      ffi.C_uint8,
+	"short":              ffi.C_int16,
+	"unsigned short":     ffi.C_uint16,
+	"int":                ffi.C_int32,
+	"unsigned int":       ffi.C_uint32,
+	"long":               ffi.C_int64,
+	"unsigned long":      ffi.C_uint64,
+	"long long":          ffi.C_int64,
+	"unsigned long long": ffi.C_uint64,
+	"float":              ffi.C_float,
+	"double":             ffi.C_double,
+	"bool":               ffi.C_int8,
+
+	// leaf type codes (x/F)
+	"B": ffi.C_int8,
+	"b": ffi.C_uint8,
+	"S": ffi.C_int16,
+	"s": ffi.C_uint16,
+	"I": ffi.C_int32,
+	"i": ffi.C_uint32,
+	"L": ffi.C_int64,
+	"l": ffi.C_uint64,
+	"F": ffi.C_float,
+	"f": ffi.C_float,
+	"D": ffi.C_double,
+	"d": ffi.C_double,
+	"O": ffi.C_int8,
+}
+
+// ffi_type returns the C type croot reads a value of the given ROOT type into.
+func ffi_type(typename string) (ffi.Type, error) {
+	if ct, ok := ffi_types[typename]; ok {
+		return ct, nil
+	}
+	for _, prefix := range []string{"vector<", "std::vector<"} {
+		if strings.HasPrefix(typename, prefix) {
+			elem := strings.TrimSpace(typename[len(prefix) : len(typename)-1])
+			ct, err := ffi_type(elem)
+			if err != nil {
+				return nil, err
+			}
+			return ffi.NewSliceType(ct)
+		}
+	}
+	return nil, fmt.Errorf("type [%s] not supported by croot", typename)
+}
+
+// EOF
@@ -0,0 +1,282 @@
+//go:build !croot
+// +build !croot
+
+package rootutils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go-hep.org/x/hep/groot"
+	"go-hep.org/x/hep/groot/rdict"
+	"go-hep.org/x/hep/groot/rtree"
+)
+
+// gtree is a TreeSource reading ROOT files with groot.
+type gtree struct {
+	f *groot.File
+	t rtree.Tree
+
+	classes map[reflect.Type][]string // names of the classes of the file, by Go type
+}
+
+func open(fname, tname string) (TreeSource, error) {
+	f, err := groot.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not open ROOT file [%s]: %v",
+			ErrFileNotFound, fname, err)
+	}
+
+	obj, err := f.Get(tname)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w: could not retrieve Tree [%s] from file [%s]: %v",
+			ErrTreeNotFound, tname, fname, err)
+	}
+
+	t, ok := obj.(rtree.Tree)
+	if !ok {
+		f.Close()
+		return nil, fmt.Errorf("%w: object [%s] from file [%s] is not a Tree (%s)",
+			ErrTreeNotFound, tname, fname, obj.Class())
+	}
+
+	return &gtree{f: f, t: t}, nil
+}
+
+func (t *gtree) Name() string {
+	return t.t.Name()
+}
+
+func (t *gtree) Entries() int64 {
+	return t.t.Entries()
+}
+
+func (t *gtree) Branches() []Branch {
+	branches := make([]Branch, 0, len(t.t.Branches()))
+	for _, br := range t.t.Branches() {
+		branches = append(branches, t.branch(br, br.Name(), false))
+	}
+	return branches
+}
+
+// leaf_classes maps the classes of the leaves of ROOT typedefs read as
+// float32 and float64 values to the names of these typedefs.
+var leaf_classes = map[string]string{
+	"TLeafF16": "Float16_t",
+	"TLeafD32": "Double32_t",
+}
+
+// branch describes the groot branch br, under the given name.
+// member tells whether br holds a data member of a split object.
+//
+// groot reads the values of branches as Go values: the names of typedefs
+// and classes are taken from the classes of the leaves and from the
+// StreamerInfos of the file rather than from these Go values.
+func (t *gtree) branch(br rtree.Branch, name string, member bool) Branch {
+	leaves := make([]Leaf, 0, len(br.Leaves()))
+	for _, leaf := range br.Leaves() {
+		if leaf.Class() == "TLeafC" {
+			// C strings are read as scalars: the length of the leaf is
+			// the maximum length of the strings.
+			leaves = append(leaves, Leaf{Name: leaf.Name(), TypeName: "C"})
+			continue
+		}
+		count, n := "", 0
+		if lc := leaf.LeafCount(); lc != nil {
+			// the number of elements is only known once an entry is read.
+			count = lc.Name()
+		} else {
+			n = leaf.Len()
+		}
+		typename := leaf_type_name(leaf.Type(), count != "")
+		if tn, ok := leaf_classes[leaf.Class()]; ok {
+			typename = tn
+		}
+		leaves = append(leaves, Leaf{
+			Name:     leaf.Name(),
+			TypeName: typename,
+			Len:      n,
+			Count:    count,
+		})
+	}
+	typename := ""
+	if len(leaves) > 0 {
+		typename = leaves[0].TypeName
+	}
+	switch {
+	case member:
+		typename = t.member_type(br, name, typename)
+	case len(br.Branches()) == 0 && br.Class() != "TBranch":
+		// objects (TBranchObject, unsplit TBranchElement)
+		if rt := go_type(br); rt != nil && rt.Kind() == reflect.Struct {
+			if class := t.class(rt); class != "" {
+				typename = class
+			}
+		}
+	}
+	if len(leaves) == 1 {
+		leaves[0].TypeName = typename
+	}
+	descr := Branch{
+		Name:     name,
+		TypeName: typename,
+		Entries:  t.t.Entries(),
+		Leaves:   leaves,
+	}
+	if len(br.Branches()) == 0 {
+		return descr
+	}
+
+	// split object: one sub-branch per data member.
+	for _, sub := range br.Branches() {
+		member := member_name(br.Name(), sub.Name())
+		descr.Branches = append(descr.Branches, t.branch(sub, member, true))
+	}
+	descr.TypeName = t.class_name(br)
+	return split_branch(descr)
+}
+
+// class_name returns the name of the class of the split objects held by
+// the branch br (the class of the objects of a TClonesArray for
+// collections), or "" if it is not known.
+//
+// groot does not export the class names recorded in TBranchElements: the
+// class is the one whose StreamerInfo describes the data members held by
+// the sub-branches of br.
+func (t *gtree) class_name(br rtree.Branch) string {
+	return t.class(go_type(br.Branches()[0]))
+}
+
+// member_type returns the type name of the data member held by the
+// sub-branch br of a split object, as recorded in the StreamerInfo of the
+// class holding it (the elements' type name for arrays), or def if it is
+// not known.
+func (t *gtree) member_type(br rtree.Branch, member, def string) string {
+	class := t.class(go_type(br))
+	if class == "" {
+		return def
+	}
+	si, err := t.f.StreamerInfo(class, -1)
+	if err != nil {
+		return def
+	}
+	member, _, _ = strings.Cut(member, "[")
+	for _, elem := range si.Elements() {
+		if elem.Name() == member {
+			// variable length arrays (SliceF32[N]) are recorded as
+			// pointers to their elements.
+			return strings.TrimSuffix(elem.TypeName(), "*")
+		}
+	}
+	return def
+}
+
+// class returns the name of the class whose objects are read as Go values
+// of type rt, or "" if it is not known.
+func (t *gtree) class(rt reflect.Type) string {
+	if rt == nil {
+		return ""
+	}
+	if t.classes == nil {
+		t.classes = make(map[reflect.Type][]string)
+		for _, si := range t.f.StreamerInfos() {
+			rt, err := rdict.TypeFromSI(t.f, si)
+			if err != nil {
+				continue
+			}
+			t.classes[rt] = append(t.classes[rt], si.Name())
+		}
+	}
+	if names := t.classes[rt]; len(names) == 1 {
+		return names[0]
+	}
+	// classes with the same data members can not be told apart.
+	return ""
+}
+
+// go_type returns the Go type the values of the branch br are read into, or
+// nil if groot does not support them.
+func go_type(br rtree.Branch) (rt reflect.Type) {
+	defer func() {
+		if recover() != nil {
+			rt = nil
+		}
+	}()
+	return br.GoType()
+}
+
+func (t *gtree) Read(vars []ReadVar, f func(ientry int64, values []reflect.Value) error) error {
+	all := rtree.NewReadVars(t.t)
+	rvars := make([]rtree.ReadVar, 0, len(vars))
+	values := make([]reflect.Value, len(vars))
+	for i, v := range vars {
+		leaf, member := v.Leaf, ""
+		if br := t.t.Branch(v.Branch); br != nil && len(br.Branches()) > 0 {
+			// data members of split objects are read with the whole object.
+			leaf, member = "", v.Leaf
+		}
+		j := find_var(rvars, v.Branch, leaf)
+		if j < 0 {
+			k := find_var(all, v.Branch, leaf)
+			if k < 0 {
+				return fmt.Errorf("rootutils: no variable %v in Tree [%s]", v, t.Name())
+			}
+			j = len(rvars)
+			rvars = append(rvars, all[k])
+		}
+		values[i] = reflect.ValueOf(rvars[j].Value).Elem()
+		if member != "" {
+			values[i] = data_member(values[i], member)
+			if !values[i].IsValid() {
+				return fmt.Errorf("rootutils: no data member [%s] in branch [%s]", member, v.Branch)
+			}
+		}
+	}
+
+	r, err := rtree.NewReader(t.t, rvars)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return r.Read(func(ctx rtree.RCtx) error {
+		return f(ctx.Entry, values)
+	})
+}
+
+// find_var returns the index of the variable reading the branch name (and
+// its leaf, if not empty) in vars, or -1.
+func find_var(vars []rtree.ReadVar, name, leaf string) int {
+	for i, v := range vars {
+		if v.Name == name && (leaf == "" || v.Leaf == leaf) {
+			return i
+		}
+	}
+	return -1
+}
+
+// data_member returns the field of the object v holding its data member
+// name, or an invalid value.
+// groot tags the fields with the names of the data members, along with
+// their dimensions (ArrayF32[10], SliceF32[N].)
+func data_member(v reflect.Value, name string) reflect.Value {
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	name, _, _ = strings.Cut(name, "[")
+	for i := 0; i < v.NumField(); i++ {
+		tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("groot"), "[")
+		if tag == name {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+func (t *gtree) Close() error {
+	return t.f.Close()
+}
+
+// EOF
@@ -0,0 +1,192 @@
+package rootutils
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// MemTree is a TreeSource holding its entries in memory.
+// It allows to exercise the schema generation and the conversion without
+// any ROOT file.
+type MemTree struct {
+	name     string
+	nentries int64
+	branches []Branch
+	values   []reflect.Value // values of each branch, one per entry
+}
+
+// NewMemTree returns a new empty in-memory tree.
+func NewMemTree(name string) *MemTree {
+	return &MemTree{name: name}
+}
+
+// AddBranch adds a branch to the tree, holding the given values.
+// values must be a slice with one element per entry of the tree.
+// The branch's type name and leaves are inferred from the type of the
+// values if they are not set: a slice of Go arrays makes a fixed length
+// array branch (x[10]/F.)
+// Variable length array branches (px[nPart]/F) hold slices and must declare
+// their counter leaf explicitly.
+// Multi-leaf branches (x/F:y/F:z/F) hold structs, with one field per leaf.
+// Split objects hold structs too, with one field per data member, and are
+// requested with a non-nil Branches; collections of split objects hold
+// slices of structs.
+func (t *MemTree) AddBranch(br Branch, values interface{}) error {
+	rv := reflect.ValueOf(values)
+	if rv.Kind() != reflect.Slice {
+		return fmt.Errorf("rootutils: branch [%s]: values must be a slice (got %T)",
+			br.Name, values)
+	}
+	n := int64(rv.Len())
+	if len(t.branches) > 0 && n != t.nentries {
+		return fmt.Errorf("rootutils: branch [%s]: invalid number of entries (got %d, want %d)",
+			br.Name, n, t.nentries)
+	}
+	switch et := rv.Type().Elem(); {
+	case et.Kind() == reflect.Slice && et.Elem().Kind() == reflect.Struct:
+		// collection of split objects.
+		br = mem_split_branch(br, et.Elem(), br.Name+"_")
+	case et.Kind() == reflect.Struct && br.Branches != nil:
+		// split object.
+		br = mem_split_branch(br, et, "")
+	case et.Kind() == reflect.Struct && len(br.Leaves) == 0:
+		// multi-leaf branch, one leaf per field of the struct.
+		for i := 0; i < et.NumField(); i++ {
+			ft := et.Field(i)
+			leaf := Leaf{Name: ft.Name, TypeName: leaf_type_name(ft.Type, false)}
+			if ft.Type.Kind() == reflect.Array {
+				leaf.Len = ft.Type.Len()
+			}
+			br.Leaves = append(br.Leaves, leaf)
+		}
+	}
+	if len(br.Leaves) == 0 && len(br.Branches) == 0 {
+		leaf := Leaf{Name: br.Name, TypeName: br.TypeName}
+		if et := rv.Type().Elem(); et.Kind() == reflect.Array {
+			leaf.Len = et.Len()
+		}
+		if leaf.TypeName == "" {
+			leaf.TypeName = leaf_type_name(rv.Type().Elem(), false)
+		}
+		br.Leaves = []Leaf{leaf}
+	}
+	if br.TypeName == "" && len(br.Leaves) > 0 {
+		br.TypeName = br.Leaves[0].TypeName
+	}
+	br.Entries = n
+	for i := range br.Branches {
+		br.Branches[i].Entries = n
+	}
+
+	t.nentries = n
+	t.branches = append(t.branches, br)
+	t.values = append(t.values, rv)
+	return nil
+}
+
+// mem_split_branch describes the split objects of type rt held by the
+// branch br, with one sub-branch per field of rt.
+// The data members of collections are counted by the leaf count.
+func mem_split_branch(br Branch, rt reflect.Type, count string) Branch {
+	if br.TypeName == "" {
+		br.TypeName = rt.Name()
+	}
+	br.Leaves = nil
+	br.Branches = make([]Branch, 0, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		ft := rt.Field(i)
+		leaf := Leaf{Name: ft.Name, TypeName: leaf_type_name(ft.Type, false), Count: count}
+		if ft.Type.Kind() == reflect.Array {
+			leaf.Len = ft.Type.Len()
+		}
+		br.Branches = append(br.Branches, Branch{
+			Name:     ft.Name,
+			TypeName: leaf.TypeName,
+			Leaves:   []Leaf{leaf},
+		})
+	}
+	return split_branch(br)
+}
+
+func (t *MemTree) Name() string {
+	return t.name
+}
+
+func (t *MemTree) Entries() int64 {
+	return t.nentries
+}
+
+func (t *MemTree) Branches() []Branch {
+	return t.branches
+}
+
+func (t *MemTree) Read(vars []ReadVar, f func(ientry int64, values []reflect.Value) error) error {
+	ibrs := make([]int, len(vars))
+	ileaves := make([]int, len(vars))
+	for i, v := range vars {
+		ibrs[i] = -1
+		ileaves[i] = -1
+		for j, br := range t.branches {
+			if br.Name != v.Branch {
+				continue
+			}
+			ibrs[i] = j
+			leaves := br.Leaves
+			if len(br.Branches) > 0 {
+				leaves = make([]Leaf, len(br.Branches))
+				for k, sub := range br.Branches {
+					leaves[k] = Leaf{Name: sub.Name}
+				}
+			} else if v.Leaf == "" || len(br.Leaves) == 1 {
+				break
+			}
+			for k, leaf := range leaves {
+				if leaf.Name == v.Leaf {
+					ileaves[i] = k
+				}
+			}
+			if ileaves[i] < 0 {
+				ibrs[i] = -1
+			}
+			break
+		}
+		if ibrs[i] < 0 {
+			return fmt.Errorf("rootutils: no variable %v in Tree [%s]", v, t.name)
+		}
+	}
+
+	values := make([]reflect.Value, len(vars))
+	for ientry := int64(0); ientry < t.nentries; ientry++ {
+		for i, j := range ibrs {
+			values[i] = t.values[j].Index(int(ientry))
+			if ileaves[i] < 0 {
+				continue
+			}
+			if !t.branches[j].Collection {
+				values[i] = values[i].Field(ileaves[i])
+				continue
+			}
+			// one value per object of the collection.
+			objs := values[i]
+			v := reflect.MakeSlice(
+				reflect.SliceOf(objs.Type().Elem().Field(ileaves[i]).Type),
+				objs.Len(), objs.Len(),
+			)
+			for k := 0; k < objs.Len(); k++ {
+				v.Index(k).Set(objs.Index(k).Field(ileaves[i]))
+			}
+			values[i] = v
+		}
+		err := f(ientry, values)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *MemTree) Close() error {
+	return nil
+}
+
+// EOF
@@ -0,0 +1,194 @@
+// Package "rootutils" provides access to the content of ROOT trees,
+// independently of the library used to read ROOT files.
+//
+// By default, ROOT files are read with the pure-Go go-hep.org/x/hep/groot
+// package. Building with the "croot" tag reads them with
+// github.com/go-hep/croot instead, which needs a C++ ROOT installation.
+package rootutils
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+var (
+	// ErrFileNotFound is returned when a ROOT file could not be opened.
+	ErrFileNotFound = errors.New("rootutils: ROOT file not found")
+
+	// ErrTreeNotFound is returned when a ROOT file holds no tree with the
+	// requested name.
+	ErrTreeNotFound = errors.New("rootutils: Tree not found")
+)
+
+// TreeSource is a flat ROOT n-tuple.
+type TreeSource interface {
+	// Name returns the name of the tree.
+	Name() string
+
+	// Entries returns the number of entries of the tree.
+	Entries() int64
+
+	// Branches returns the top-level branches of the tree.
+	Branches() []Branch
+
+	// Read loads the entries of the tree one after the other, reading only
+	// the requested variables, and calls f with their values.
+	// The values are only valid during the call to f.
+	Read(vars []ReadVar, f func(ientry int64, values []reflect.Value) error) error
+
+	// Close releases the resources held by the tree and its file.
+	Close() error
+}
+
+// Branch describes a branch of a tree.
+//
+// Branches of split objects hold one sub-branch per data member of their
+// class, named after that data member.
+// For collections of objects (TClonesArray), each sub-branch holds the
+// values of its data member for all the objects of the collection.
+type Branch struct {
+	Name     string // name of the branch
+	TypeName string // C++ type name of the branch (e.g. "Float_t", "vector<float>"), or of the objects of a collection
+	Entries  int64  // number of entries of the branch
+	Leaves   []Leaf // leaves of the branch
+
+	Branches   []Branch // sub-branches of a split object
+	Collection bool     // whether the branch holds a collection of split objects
+}
+
+// ReadVar describes a variable to read from a tree: the value of a branch,
+// the value of one of the leaves of a multi-leaf branch (x/F:y/F:z/F) or
+// the value of one of the data members of a split object.
+//
+// The values of the data members of a collection of split objects are
+// read as slices, with one element per object of the collection.
+type ReadVar struct {
+	Branch string // name of the branch
+	Leaf   string // name of the leaf or data member, for multi-leaf and split branches
+}
+
+// Leaf describes a leaf of a branch.
+//
+// Leaves of C-style array branches hold their elements' type name, with
+// either the fixed length of the array (x[10]/F) or the name of the leaf
+// counting its elements (px[nPart]/F.)
+type Leaf struct {
+	Name     string // name of the leaf
+	TypeName string // C++ type name of the leaf, or of its elements for arrays
+	Len      int    // fixed number of elements of the leaf (0 or 1 for scalars)
+	Count    string // name of the leaf counting the elements of the leaf, if any
+}
+
+// IsArray returns whether the leaf is a fixed or variable length array.
+func (leaf Leaf) IsArray() bool {
+	return leaf.Len > 1 || leaf.Count != ""
+}
+
+// split_branch completes the description of a split object branch out of
+// its sub-branches: the branch holds a collection when all its data members
+// are counted by the same leaf.
+func split_branch(br Branch) Branch {
+	if len(br.Branches) == 0 {
+		return br
+	}
+	count := br.Branches[0].count()
+	br.Collection = count != ""
+	for _, sub := range br.Branches {
+		if sub.count() != count {
+			br.Collection = false
+		}
+	}
+	return br
+}
+
+// count returns the name of the leaf counting the elements of a single-leaf
+// branch, if any.
+func (br Branch) count() string {
+	if len(br.Leaves) != 1 {
+		return ""
+	}
+	return br.Leaves[0].Count
+}
+
+// member_name returns the name of the data member held by the sub-branch
+// sub of the branch parent (e.g. "tracks.fPx" -> "fPx".)
+func member_name(parent, sub string) string {
+	return strings.TrimPrefix(sub, parent+".")
+}
+
+// Open opens the tree tname from the ROOT file fname.
+func Open(fname, tname string) (TreeSource, error) {
+	return open(fname, tname)
+}
+
+// type_names maps the Go types of the values read from a tree to the names
+// of the corresponding ROOT types.
+var type_names = map[reflect.Kind]string{
+	reflect.Bool:    "Bool_t",
+	reflect.Int8:    "Char_t",
+	reflect.Uint8:   "UChar_t",
+	reflect.Int16:   "Short_t",
+	reflect.Uint16:  "UShort_t",
+	reflect.Int32:   "Int_t",
+	reflect.Uint32:  "UInt_t",
+	reflect.Int64:   "Long64_t",
+	reflect.Uint64:  "ULong64_t",
+	reflect.Float32: "Float_t",
+	reflect.Float64: "Double_t",
+	reflect.String:  "string",
+}
+
+// cxx_names maps the Go types of the values read from a tree to the names
+// of the corresponding C++ types, as used in STL containers' class names.
+var cxx_names = map[reflect.Kind]string{
+	reflect.Bool:    "bool",
+	reflect.Int8:    "char",
+	reflect.Uint8:   "unsigned char",
+	reflect.Int16:   "short",
+	reflect.Uint16:  "unsigned short",
+	reflect.Int32:   "int",
+	reflect.Uint32:  "unsigned int",
+	reflect.Int64:   "long",
+	reflect.Uint64:  "unsigned long",
+	reflect.Float32: "float",
+	reflect.Float64: "double",
+	reflect.String:  "string",
+}
+
+// type_name returns the name of the ROOT type of a value read from a tree
+// as a Go value of type rt.
+func type_name(rt reflect.Type) string {
+	if rt.Kind() == reflect.Slice {
+		return "vector<" + cxx_name(rt.Elem()) + ">"
+	}
+	if n, ok := type_names[rt.Kind()]; ok {
+		return n
+	}
+	return rt.String()
+}
+
+// leaf_type_name returns the name of the ROOT type of the values of a leaf
+// read as Go values of type rt. The elements' type name is returned for
+// arrays, countable or not.
+func leaf_type_name(rt reflect.Type, counted bool) string {
+	switch {
+	case rt.Kind() == reflect.Array:
+		rt = rt.Elem()
+	case rt.Kind() == reflect.Slice && counted:
+		rt = rt.Elem()
+	}
+	return type_name(rt)
+}
+
+func cxx_name(rt reflect.Type) string {
+	if rt.Kind() == reflect.Slice {
+		return "vector<" + cxx_name(rt.Elem()) + ">"
+	}
+	if n, ok := cxx_names[rt.Kind()]; ok {
+		return n
+	}
+	return rt.String()
+}
+
+// EOF
@@ -0,0 +1,150 @@
+package rootutils
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// find_branch returns the description of the branch name of tree.
+func find_branch(t *testing.T, tree TreeSource, name string) Branch {
+	for _, br := range tree.Branches() {
+		if br.Name == name {
+			return br
+		}
+	}
+	t.Fatalf("no branch [%s] in tree [%s]", name, tree.Name())
+	return Branch{}
+}
+
+func TestCString(t *testing.T) {
+	tree, err := Open("testdata/small-flat-tree.root", "tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	// C strings (Str/C) are scalars, whatever their maximum length.
+	br := find_branch(t, tree, "Str")
+	if want := []Leaf{{Name: "Str", TypeName: "C"}}; !reflect.DeepEqual(br.Leaves, want) {
+		t.Fatalf("invalid leaves: got %+v, want %+v", br.Leaves, want)
+	}
+	if br.TypeName != "C" || br.Leaves[0].IsArray() {
+		t.Fatalf("invalid branch description: %+v", br)
+	}
+
+	var strs []string
+	err = tree.Read([]ReadVar{{Branch: "Str"}}, func(ientry int64, values []reflect.Value) error {
+		if ientry < 3 {
+			strs = append(strs, values[0].Interface().(string))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"evt-000", "evt-001", "evt-002"}; !reflect.DeepEqual(strs, want) {
+		t.Fatalf("invalid values: got %q, want %q", strs, want)
+	}
+}
+
+func TestSplitObject(t *testing.T) {
+	tree, err := Open("testdata/small-evnt-tree-fullsplit.root", "tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	br := find_branch(t, tree, "evt")
+	if br.TypeName != "Event" || br.Collection {
+		t.Fatalf("invalid evt branch: %s (collection=%v)", br.TypeName, br.Collection)
+	}
+	var p3 *Branch
+	for i, sub := range br.Branches {
+		if sub.Name == "P3" {
+			p3 = &br.Branches[i]
+		}
+	}
+	if p3 == nil {
+		t.Fatalf("no P3 data member in %+v", br.Branches)
+	}
+	// nested split objects are named after their own class, not after the
+	// class holding them.
+	if p3.TypeName != "P3" {
+		t.Fatalf("invalid P3 class: got %q, want P3", p3.TypeName)
+	}
+	var members []string
+	for _, sub := range p3.Branches {
+		members = append(members, sub.Name)
+	}
+	if want := []string{"Px", "Py", "Pz"}; !reflect.DeepEqual(members, want) {
+		t.Fatalf("invalid P3 data members: got %v, want %v", members, want)
+	}
+
+	vars := []ReadVar{
+		{Branch: "evt", Leaf: "I32"},
+		{Branch: "evt", Leaf: "Str"},
+		{Branch: "evt", Leaf: "SliceI32"},
+	}
+	err = tree.Read(vars, func(ientry int64, values []reflect.Value) error {
+		if got := values[0].Int(); got != ientry {
+			t.Fatalf("entry %d: invalid I32: got %d", ientry, got)
+		}
+		if got, want := values[1].String(), fmt.Sprintf("evt-%03d", ientry); got != want {
+			t.Fatalf("entry %d: invalid Str: got %q, want %q", ientry, got, want)
+		}
+		if got, want := values[2].Len(), int(ientry%10); got != want {
+			t.Fatalf("entry %d: invalid SliceI32 length: got %d, want %d", ientry, got, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTypeNames(t *testing.T) {
+	for _, tc := range []struct {
+		fname  string
+		branch string
+		member string // data member of a split object
+		want   Leaf
+	}{
+		// typedefs read as float32 and float64 values.
+		{"leaves.root", "D16", "", Leaf{Name: "D16", TypeName: "Float16_t", Len: 1}},
+		{"leaves.root", "D32", "", Leaf{Name: "D32", TypeName: "Double32_t", Len: 1}},
+		{"leaves.root", "ArrD32", "", Leaf{Name: "ArrD32", TypeName: "Double32_t", Len: 10}},
+		{"leaves.root", "SliD32", "", Leaf{Name: "SliD32", TypeName: "Double32_t", Count: "N"}},
+		// objects, read as Go types registered by groot.
+		{"tlv-split00.root", "p4", "", Leaf{Name: "p4", TypeName: "TLorentzVector", Len: 1}},
+		// data members, named after their C++ type.
+		{"small-evnt-tree-fullsplit.root", "evt", "I16", Leaf{Name: "I16", TypeName: "short", Len: 1}},
+		{"small-evnt-tree-fullsplit.root", "evt", "Str", Leaf{Name: "Str", TypeName: "TString", Len: 1}},
+		{"small-evnt-tree-fullsplit.root", "evt", "StdStr", Leaf{Name: "StdStr", TypeName: "string", Len: 1}},
+		{"small-evnt-tree-fullsplit.root", "evt", "SliceF64", Leaf{Name: "SliceF64", TypeName: "double", Count: "N"}},
+		{"small-evnt-tree-fullsplit.root", "evt", "StlVecU16", Leaf{Name: "StlVecU16", TypeName: "vector<unsigned short>", Len: 1}},
+	} {
+		t.Run(tc.branch+"."+tc.member, func(t *testing.T) {
+			tree, err := Open("testdata/"+tc.fname, "tree")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+
+			br := find_branch(t, tree, tc.branch)
+			for _, sub := range br.Branches {
+				if sub.Name == tc.member {
+					br = sub
+				}
+			}
+			if len(br.Leaves) != 1 {
+				t.Fatalf("invalid leaves: %+v", br.Leaves)
+			}
+			if br.TypeName != tc.want.TypeName || br.Leaves[0] != tc.want {
+				t.Fatalf("invalid type: got %s %+v, want %+v", br.TypeName, br.Leaves[0], tc.want)
+			}
+		})
+	}
+}
+
+// EOF
@@ -0,0 +1,40 @@
+// Package "templates" holds the templates of the files generated by
+// go-root2pb, embedded in its binary.
+package templates
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// FS holds the templates:
+//   - proto2.proto.tmpl: the .proto file of a schema, with the proto2 syntax,
+//   - proto3.proto.tmpl: the .proto file of a schema, with the proto3 syntax,
+//   - options.proto.tmpl: the .proto file declaring the custom options
+//     (root_branch, root_leaf) of a proto3 schema,
+//   - cnv.go.tmpl: the standalone converter program built by -gencnv.
+//
+//go:embed *.tmpl
+var FS embed.FS
+
+// Dir returns the templates of the directory dir, falling back to the
+// embedded ones for the templates dir does not hold.
+func Dir(dir string) fs.FS {
+	return overlay{os.DirFS(dir)}
+}
+
+type overlay struct {
+	dir fs.FS
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	f, err := o.dir.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return FS.Open(name)
+	}
+	return f, err
+}
+
+// EOF
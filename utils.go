@@ -2,20 +2,19 @@ package main
 
 import (
 	"fmt"
-	go_build "go/build"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
+	"runtime/debug"
 	"strings"
 	"text/template"
 
-	"code.google.com/p/goprotobuf/proto"
-	pb_descr "code.google.com/p/goprotobuf/protoc-gen-go/descriptor"
-	pb_gen "code.google.com/p/goprotobuf/protoc-gen-go/generator"
-	"github.com/go-hep/croot"
+	"github.com/sbinet/go-root2pb/root2pb"
 )
 
 func path_exists(name string) bool {
@@ -29,294 +28,265 @@ func path_exists(name string) bool {
 	return false
 }
 
-func get_templates_dir() string {
-	const dirname = "github.com/sbinet/go-root2pb/templates"
-	for _, srcdir := range go_build.Default.SrcDirs() {
-		dir := path.Join(srcdir, dirname)
-		if path_exists(dir) {
-			return dir
+// write_proto writes the .proto file describing schema into oname, and the
+// .proto file declaring the custom options next to it for proto3, out of
+// the templates tmpls or, if not empty, of the user template ptempl.
+// It returns the names of the written files.
+func write_proto(oname string, schema *root2pb.Schema, tmpls fs.FS, ptempl string) ([]string, error) {
+	onames := []string{oname}
+	err := write_file(oname, func(w io.Writer) error {
+		if ptempl != "" {
+			return root2pb.GenerateProtoTemplate(schema, tmpls, ptempl, w)
 		}
+		return root2pb.GenerateProtoFS(schema, tmpls, w)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if schema.OptionsFile() != "" {
+		fname := path.Join(path.Dir(oname), schema.OptionsFile())
+		err = write_file(fname, func(w io.Writer) error {
+			return root2pb.GenerateOptionsFS(schema, tmpls, w)
+		})
+		if err != nil {
+			return nil, err
+		}
+		onames = append(onames, fname)
 	}
-	return ""
+	return onames, nil
 }
 
-func inspect_root_file(filename, treename string) []pb_field {
-
-	f := croot.OpenFile(filename, "read", "ROOT file", 1, 0)
-	if f == nil {
-		fmt.Printf("**error** could not open ROOT file [%s]\n", filename)
-		os.Exit(1)
+// write_file creates the file fname and fills it with gen.
+func write_file(fname string, gen func(w io.Writer) error) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
 	}
-	defer f.Close("")
+	defer f.Close()
 
-	tree := f.GetTree(treename)
-	if tree == nil {
-		fmt.Printf("**error** could not retrieve Tree [%s] from file [%s]\n",
-			treename, filename)
+	err = gen(f)
+	if err != nil {
+		return err
 	}
+	return f.Close()
+}
 
-	//tree.Print("*")
-
-	branches := tree.GetListOfBranches()
-	fmt.Printf("   #-branches: %v\n", branches.GetSize())
-
-	imax := branches.GetSize()
-
-	type stringset map[string]struct{}
+// go_import_path returns the import path of the Go package generated for
+// the protobuf package pkg.
+func go_import_path(pkg string) string {
+	return path.Join(cnv_module, pkg)
+}
 
-	pb_fields := []pb_field{}
+// has_go_package returns whether the .proto file fname sets the go_package
+// option.
+func has_go_package(fname string) bool {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return false
+	}
+	return regexp.MustCompile(`(?m)^\s*option\s+go_package\s*=`).Match(data)
+}
 
-	for i := int64(0); i < imax; i++ {
-		obj := branches.At(i)
-		br := obj.(croot.Branch)
-		typename := br.GetClassName()
-		if typename == "" {
-			leaf := tree.GetLeaf(br.GetName())
-			typename = leaf.GetTypeName()
-		}
-		if *verbose {
-			fmt.Printf(" [%d] -> [%v] (%v) (type:%v)\n", i, obj.GetName(), br.ClassName(), typename)
-		}
-		name := br.GetName()
-		pb_type, isrepeated := get_pb_type(typename)
-		accept := true
-		if *brsel != "" {
-			accept = false
-			for _, pattern := range strings.Split(*brsel, ",") {
-				switch pattern[0] {
-				case '-':
-					matched, err := filepath.Match(pattern[1:], name)
-					if err == nil && matched {
-						accept = false
-						break
-					}
-				case '+':
-					matched, err := filepath.Match(pattern[1:], name)
-					if err == nil && matched {
-						accept = true
-						break
-					}
-				default:
-					matched, err := filepath.Match(pattern, name)
-					if err == nil && matched {
-						accept = true
-						break
-					}
-				}
+// run_protoc runs protoc on the .proto files onames, generating the pb files
+// for the comma-separated list of languages langs (go,py,cpp,java) and the
+// descriptor set dname.
+// The Go files are generated next to the .proto files, as the Go package
+// gopkg unless the .proto files set their go_package option.
+func run_protoc(langs, dname, gopkg string, onames ...string) error {
+	args := []string{}
+	if strings.Contains(langs, "go") {
+		args = append(args, "--go_out=.", "--go_opt=paths=source_relative")
+		for _, oname := range onames {
+			if has_go_package(oname) {
+				// set by a user template (-proto-template.)
+				continue
 			}
+			args = append(args, fmt.Sprintf("--go_opt=M%s=%s", path.Base(oname), gopkg))
 		}
-		if accept {
-			pb_fields = append(pb_fields,
-				pb_field{
-					Name:     pb_gen.CamelCase(name),
-					Type:     pb_type,
-					Id:       <-gen_id,
-					Branch:   name,
-					repeated: isrepeated,
-				})
-		}
+	}
+	if strings.Contains(langs, "py") {
+		args = append(args, "--python_out=.")
+	}
+	if strings.Contains(langs, "java") {
+		args = append(args, "--java_out=.")
+	}
+	if strings.Contains(langs, "cpp") ||
+		strings.Contains(langs, "cxx") {
+		args = append(args, "--cpp_out=.")
 	}
 
-	return pb_fields
+	outdir := path.Dir(onames[0])
+	args = append(args,
+		fmt.Sprintf("--descriptor_set_out=%s", dname),
+		"--include_imports",
+		"-I", outdir,
+		"-I", "/usr/include",
+	)
+	args = append(args, onames...)
+	cmd := exec.Command("protoc", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = outdir
+	return cmd.Run()
 }
 
-func convert_tree(filename, treename, descr_fname string) error {
-	var err error
-
-	// first create a workdir
-	wkdir, err := ioutil.TempDir("", "go-root2pb-")
-	if err != nil {
-		return err
-	}
-	//fmt.Printf("wkdir: %v\n", wkdir)
-	err = os.MkdirAll(wkdir, os.ModeDir|os.ModePerm)
-	if err != nil {
-		return err
-	}
-	//defer os.RemoveAll(wkdir)
+// get_pbuf_name returns the name of the .pbuf file holding the content of
+// the ROOT file filename, next to the descriptor file.
+func get_pbuf_name(filename, descr_fname string) string {
+	oname := path.Base(filename)
+	return filepath.Join(
+		path.Dir(descr_fname),
+		strings.Replace(oname, ".root", ".pbuf", -1),
+	)
+}
 
-	for _, dirname := range []string{
-		path.Join("pkg", go_build.Default.GOOS+"_"+go_build.Default.GOARCH),
-		"bin",
-	} {
-		dir := path.Join(wkdir, dirname)
-		err = os.MkdirAll(dir, os.ModeDir|os.ModePerm)
-		if err != nil {
-			return err
-		}
-	}
+// gen_convert_tree converts the content of a ROOT TTree into a .pbuf file,
+// by generating, building and running a dedicated Go program (root2pb-cnv)
+// using the .pb.go package generated by protoc.
+// The program is built as a Go module, in a temporary directory, out of the
+// templates tmpls.
+func gen_convert_tree(filename, treename, descr_fname, oname string, tmpls fs.FS) error {
+	var err error
 
-	srcdir := path.Join(wkdir, "src")
-	err = os.MkdirAll(srcdir, os.ModeDir|os.ModePerm)
+	descr_fname, err = filepath.Abs(descr_fname)
 	if err != nil {
 		return err
 	}
 
-	err = os.Chdir(srcdir)
+	// first create a workdir
+	wkdir, err := ioutil.TempDir("", "go-root2pb-")
 	if err != nil {
 		return err
 	}
-	//fmt.Printf("srcdir: %v\n", srcdir)
-	orig_gopath := go_build.Default.GOPATH
-	defer os.Setenv("GOPATH", orig_gopath)
-
-	go_build.Default.GOPATH = strings.Join(
-		[]string{wkdir, go_build.Default.GOPATH},
-		string(filepath.ListSeparator))
-	//fmt.Printf("GOPATH: %v\n", go_build.Default.GOPATH)
-	err = os.Setenv("GOPATH", go_build.Default.GOPATH)
+	defer os.RemoveAll(wkdir)
 
-	data, err := ioutil.ReadFile(descr_fname)
+	fdset, err := root2pb.LoadDescriptorSet(descr_fname)
 	if err != nil {
 		fmt.Printf("**error** reading descriptor file: %v\n", err)
 		return err
 	}
-	fdset := pb_descr.FileDescriptorSet{}
-	err = proto.Unmarshal(data, &fdset)
+
+	// the event message is the one whose fields carry the (root_branch)
+	// option, as for the in-process conversion.
+	descr, err := root2pb.EventDescriptor(fdset)
 	if err != nil {
 		return err
 	}
+	pb_pkg_name := go_import_path(string(descr.ParentFile().Package()))
+	pb_msg_name := string(descr.Name())
 
-	pb_pkg_name := ""
-	pb_msg_name := ""
-
-	//fmt.Printf(":: fdset: %v\n", len(fdset.File))
 	for _, fd := range fdset.File {
-		// fmt.Printf(" name=%q\n", fd.GetName())
-		// fmt.Printf(" pkg=%q\n", fd.GetPackage())
-		// fmt.Printf(" deps=%v\n", fd.Dependency)
-		// fmt.Printf(" public-deps=%v\n", fd.PublicDependency)
-		// fmt.Printf(" #-msgs=%d\n", len(fd.MessageType))
-		for _, msg := range fd.MessageType {
-			// fmt.Printf("  msg[%d]: %v\n", imsg, msg.GetName())
-			if msg.GetName() != "DataHeader" {
-				pb_msg_name = msg.GetName()
-			}
-			// for _, field := range msg.Field {
-			// 	typename := ""
-			// 	if field.TypeName != nil {
-			// 		typename = *field.TypeName
-			// 	}
-			// 	fmt.Printf("    field: %q type=%v name=%q\n", *field.Name, *field.Type, typename)
-			// }
+		if fd.GetPackage() == "google.protobuf" {
+			// imported by the .proto file, provided by google.golang.org/protobuf.
+			continue
 		}
 		// create protobuf data package
-		pb_pkg_name = path.Join("root2pb-data", *fd.Package)
-		pkgdir := path.Join(srcdir, pb_pkg_name)
-		// fmt.Printf("-->pkgdir: %v\n", pkgdir)
+		pkgdir := filepath.Join(wkdir, strings.TrimPrefix(go_import_path(fd.GetPackage()), cnv_module+"/"))
 		err = os.MkdirAll(pkgdir, os.ModeDir|os.ModePerm)
 		if err != nil {
 			return err
 		}
-		files, err := filepath.Glob(path.Join(filepath.Dir(descr_fname), "*"))
+		files, err := filepath.Glob(filepath.Join(filepath.Dir(descr_fname), "*.pb.go"))
 		if err != nil {
 			return err
 		}
 		for _, fname := range files {
-			dest, err := os.Create(path.Join(pkgdir, filepath.Base(fname)))
-			if err != nil {
-				return err
-			}
-			src, err := os.Open(fname)
-			if err != nil {
-				return err
-			}
-			// fixup...
-			if strings.HasSuffix(fname, ".pb.go") {
-				data, err := ioutil.ReadFile(fname)
-				sdata := strings.Replace(string(data),
-					"google/protobuf/descriptor.pb",
-					"code.google.com/p/goprotobuf/protoc-gen-go/descriptor",
-					-1)
-				_, err = dest.WriteString(sdata)
-				if err != nil {
-					return err
-				}
-
-			} else {
-				_, err = io.Copy(dest, src)
-				if err != nil {
-					return err
-				}
-			}
-			err = dest.Sync()
+			err = copy_file(filepath.Join(pkgdir, filepath.Base(fname)), fname)
 			if err != nil {
 				return err
 			}
 		}
-		cmd := exec.Command("go", "get", ".")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Dir = pkgdir
-		err = cmd.Run()
-		if err != nil {
-			return err
-		}
 	}
 
-	tmpldir := get_templates_dir()
-
-	//fmt.Printf("tmpldir: %v\n", tmpldir)
-	t, err := template.ParseFiles(path.Join(tmpldir, "cnv.go"))
+	gomod, err := cnv_gomod()
 	if err != nil {
-		fmt.Printf("**error** parsing template file: %v\n", err)
 		return err
 	}
-
-	err = os.MkdirAll(path.Join(srcdir, "root2pb-cnv"), os.ModeDir|os.ModePerm)
+	err = ioutil.WriteFile(filepath.Join(wkdir, "go.mod"), []byte(gomod), 0644)
 	if err != nil {
 		return err
 	}
-	cnv_fname := path.Join(srcdir, "root2pb-cnv", "cnv.go")
-	//fmt.Printf("cnv: %v\n", cnv_fname)
-	cnv, err := os.Create(cnv_fname)
+
+	t, err := template.ParseFS(tmpls, "cnv.go.tmpl")
 	if err != nil {
+		fmt.Printf("**error** parsing template file: %v\n", err)
 		return err
 	}
 
 	tmpl_data := map[string]string{
-		"Package":    fmt.Sprintf(`%q`, pb_pkg_name),
-		"DataHeader": "DataHeader",
-		"Event":      pb_msg_name,
-		"FdSet":      descr_fname,
+		"Package": fmt.Sprintf(`%q`, pb_pkg_name),
+		"Event":   pb_msg_name,
+		"FdSet":   descr_fname,
 	}
-	err = t.Execute(cnv, tmpl_data)
-	//err = t.Execute(os.Stdout, tmpl_data)
+	err = write_file(filepath.Join(wkdir, "cnv.go"), func(w io.Writer) error {
+		return t.Execute(w, tmpl_data)
+	})
 	if err != nil {
 		return err
 	}
 
-	cmd := exec.Command("go", "get", ".")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = filepath.Dir(cnv_fname)
-	err = cmd.Run()
-	if err != nil {
-		return err
+	cnv := filepath.Join(wkdir, "root2pb-cnv")
+	for _, args := range [][]string{
+		{"mod", "tidy"},
+		{"build", "-o", cnv, "."},
+	} {
+		cmd := exec.Command("go", args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Dir = wkdir
+		err = cmd.Run()
+		if err != nil {
+			return fmt.Errorf("go %s: %v", strings.Join(args, " "), err)
+		}
 	}
 
-	oname := path.Base(filename)
-	oname = filepath.Join(
-		path.Dir(descr_fname),
-		strings.Replace(oname, ".root", ".pbuf", -1),
-	)
-
 	args := []string{
 		"-fname", filename,
 		"-tname", treename,
 		"-evtmax", "-1",
 		"-oname", oname,
 	}
-	cmd = exec.Command(filepath.Join(wkdir, "bin", "root2pb-cnv"), args...)
+	cmd := exec.Command(cnv, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	cmd.Dir = wkdir
-	err = cmd.Run()
+	return cmd.Run()
+}
+
+// cnv_module is the path of the Go module of the generated converter,
+// holding the .pb.go packages.
+const cnv_module = "root2pb-data"
+
+// cnv_gomod returns the go.mod file of the generated converter.
+// The converter uses the version of go-root2pb this program has been built
+// with or, for a development build, the sources of the go-root2pb module of
+// the current directory.
+func cnv_gomod() (string, error) {
+	const mod = "github.com/sbinet/go-root2pb"
+	gomod := fmt.Sprintf("module %s\n\ngo 1.22\n\n", cnv_module)
+	bi, ok := debug.ReadBuildInfo()
+	if ok && bi.Main.Path == mod && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		return gomod + fmt.Sprintf("require %s %s\n", mod, bi.Main.Version), nil
+	}
+
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", mod).Output()
 	if err != nil {
-		return err
+		return "", fmt.Errorf("could not locate the sources of %s (run from its module): %v", mod, err)
 	}
+	dir := strings.TrimSpace(string(out))
+	return gomod + fmt.Sprintf("require %s v0.0.0\n\nreplace %s => %s\n", mod, mod, dir), nil
+}
 
-	return err
+// copy_file copies the content of the file src into the new file dst.
+func copy_file(dst, src string) error {
+	return write_file(dst, func(w io.Writer) error {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
 }
 
 // EOF